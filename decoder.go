@@ -0,0 +1,64 @@
+package gcfg
+
+import (
+	"io"
+	"io/fs"
+
+	"github.com/grian32/gcfg/lexer"
+	"github.com/grian32/gcfg/parser"
+)
+
+// Decoder reads a config from a stream, lexing it as it's read instead of loading it all into memory first.
+type Decoder struct {
+	r          io.Reader
+	filename   string
+	parserMode parser.Mode
+	coerce     bool
+	maxDepth   int
+	fsys       fs.FS
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// SetFilename sets the filename errors are reported against, like UnmarshalNamed does.
+func (dec *Decoder) SetFilename(filename string) {
+	dec.filename = filename
+}
+
+// AllowDuplicates makes a key or section that's declared more than once replace the earlier declaration, instead of
+// being an error.
+func (dec *Decoder) AllowDuplicates() {
+	dec.parserMode |= parser.AllowDuplicates
+}
+
+// MergeSections makes a section that's declared more than once add up the keys of each block, instead of being an
+// error. A key declared in more than one of the blocks is still an error.
+func (dec *Decoder) MergeSections() {
+	dec.parserMode |= parser.MergeSections
+}
+
+// AllowCoercion lets a string like "8080" decode into an integer field, and an integer into a string field, as they
+// used to before the two were kept apart.
+func (dec *Decoder) AllowCoercion() {
+	dec.coerce = true
+}
+
+// SetMaxDepth lets sections and section arrays be nested inside each other, and decoded into nested structs, up to
+// depth levels deep. The default of 1 keeps sections flat.
+func (dec *Decoder) SetMaxDepth(depth int) {
+	dec.maxDepth = depth
+}
+
+// SetFS lets the config @include other files, read from fsys. Included paths are relative to the including file, the
+// top level one being the filename set with SetFilename.
+func (dec *Decoder) SetFS(fsys fs.FS) {
+	dec.fsys = fsys
+}
+
+// Decode reads the rest of the stream as a single config and stores it in v, which follows the same rules as for
+// Unmarshal.
+func (dec *Decoder) Decode(v any) error {
+	return dec.decode(lexer.NewReaderNamed(dec.filename, dec.r), v)
+}
@@ -3,6 +3,7 @@ package gcfg
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"reflect"
 	"strconv"
 	"strings"
@@ -13,9 +14,36 @@ import (
 )
 
 func Unmarshal(input []byte, v any) error {
-	l := lexer.New(input)
+	return UnmarshalNamed("", input, v)
+}
+
+// UnmarshalNamed is like Unmarshal, but errors are reported as filename:line:col.
+func UnmarshalNamed(filename string, input []byte, v any) error {
+	var dec Decoder
+	return dec.decode(lexer.NewNamed(filename, input), v)
+}
+
+// UnmarshalFS decodes the file name in fsys, which can @include other files from fsys.
+func UnmarshalFS(fsys fs.FS, name string, v any) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := NewDecoder(f)
+	dec.SetFilename(name)
+	dec.SetFS(fsys)
+	return dec.Decode(v)
+}
+
+// decode parses everything l produces and decodes it into v using dec's options.
+func (dec *Decoder) decode(l *lexer.Lexer, v any) error {
 	p := parser.New(l)
-	parsed, err := p.ParseFile()
+	p.SetMode(dec.parserMode)
+	p.SetMaxDepth(dec.maxDepth)
+	p.SetFS(dec.fsys)
+	file, err := p.Parse()
 	if err != nil {
 		return err
 	}
@@ -34,10 +62,25 @@ func Unmarshal(input []byte, v any) error {
 		return errors.New("value must be struct")
 	}
 
-	return fillStruct(elem, parsed, 0)
+	d := decodeState{coerce: dec.coerce, maxDepth: uint32(max(dec.maxDepth, 1))}
+	return d.fillStruct(elem, file.Decls, lexer.Position{Filename: l.Filename()}, 0)
+}
+
+type decodeState struct {
+	// coerce lets strings decode into integer fields and integers into string fields.
+	coerce bool
+	// maxDepth is how many sections deep structs can be nested.
+	maxDepth uint32
 }
 
-func fillStruct(elem reflect.Value, parsed map[string]any, recLevel uint32) error {
+// fillStruct fills the tagged fields of elem from decls, the body of a file or section that starts at pos.
+func (d *decodeState) fillStruct(elem reflect.Value, decls []parser.Decl, pos lexer.Position, recLevel uint32) error {
+	members := make(map[string][]parser.Decl, len(decls))
+	for _, decl := range decls {
+		name := parser.DeclName(decl)
+		members[name] = append(members[name], decl)
+	}
+
 	t := elem.Type()
 
 	for i := range t.NumField() {
@@ -49,171 +92,360 @@ func fillStruct(elem reflect.Value, parsed map[string]any, recLevel uint32) erro
 			continue
 		}
 
-		switch value.Kind() {
-		// so much bs duplicate code when it comes to ints here and in slices, can't rly generalize it by passing the
-		// functions or something because it has diff signatures for int and uint64
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			v, ok := parsed[tag].(string)
+		found := members[tag]
+		if len(found) == 0 {
+			return lexer.Errorf(pos, "field %s: key %s not found", field.Name, tag)
+		}
+		// a later declaration replaces an earlier one with the same name, except for section arrays which add up
+		decl := found[len(found)-1]
+
+		switch {
+		case value.Kind() == reflect.Slice && isSection(value.Type().Elem()) && !isAssignment(decl):
+			if recLevel >= d.maxDepth {
+				return d.nestingError(decl.Pos(), field.Name)
+			}
+
+			elemType := value.Type().Elem()
+			arrValue := reflect.MakeSlice(value.Type(), 0, len(found))
+
+			for _, decl := range found {
+				arr, ok := decl.(*parser.SectionArray)
+				if !ok {
+					return lexer.Errorf(decl.Pos(), "field %s: expected [%s] section array, got %s", field.Name, tag, declKind(decl))
+				}
+
+				newElem := reflect.New(elemType).Elem()
+				err := d.fillStruct(newElem, arr.Body, arr.Pos(), recLevel+1)
+				if err != nil {
+					return err
+				}
+				arrValue = reflect.Append(arrValue, newElem)
+			}
+
+			value.Set(arrValue)
+		case isSection(value.Type()):
+			if recLevel >= d.maxDepth {
+				return d.nestingError(decl.Pos(), field.Name)
+			}
+
+			body, ok := sectionBody(decl)
 			if !ok {
-				return fmt.Errorf("field %s: expected string, got %T", field.Name, parsed[tag])
+				return lexer.Errorf(decl.Pos(), "field %s: expected section, got %s", field.Name, declKind(decl))
 			}
-			bits := value.Type().Bits()
-			intVal, err := strconv.ParseInt(v, 10, bits)
 
+			err := d.fillStruct(value, body, decl.Pos(), recLevel+1)
 			if err != nil {
 				return err
 			}
-			value.SetInt(intVal)
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			v, ok := parsed[tag].(string)
+		default:
+			assign, ok := decl.(*parser.Assignment)
 			if !ok {
-				return fmt.Errorf("field %s: expected string, got %T", field.Name, parsed[tag])
+				return lexer.Errorf(decl.Pos(), "field %s: expected value, got %s", field.Name, declKind(decl))
 			}
-			bits := value.Type().Bits()
 
-			uintVal, err := strconv.ParseUint(v, 10, bits)
+			err := d.setValue(value, assign.Value, field.Name, recLevel)
 			if err != nil {
 				return err
 			}
-			value.SetUint(uintVal)
-		case reflect.String:
-			v, ok := parsed[tag].(string)
-			if !ok {
-				return fmt.Errorf("field %s: expected string, got %T", field.Name, parsed[tag])
+		}
+	}
+
+	return nil
+}
+
+// setValue decodes v into value, which belongs to the struct field named field. recLevel is the nesting level of the
+// struct the field is in, for inline sections.
+func (d *decodeState) setValue(value reflect.Value, v parser.Value, field string, recLevel uint32) error {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		literal, ok := d.intLiteral(v)
+		if !ok {
+			return lexer.Errorf(v.Pos(), "field %s: expected int, got %s", field, valueKind(v))
+		}
+
+		intVal, err := parseInt(literal, value.Type().Bits())
+		if err != nil {
+			return lexer.Errorf(v.Pos(), "field %s: %w", field, err)
+		}
+		value.SetInt(intVal)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		literal, ok := d.intLiteral(v)
+		if !ok {
+			return lexer.Errorf(v.Pos(), "field %s: expected int, got %s", field, valueKind(v))
+		}
+
+		uintVal, err := parseUint(literal, value.Type().Bits())
+		if err != nil {
+			return lexer.Errorf(v.Pos(), "field %s: %w", field, err)
+		}
+		value.SetUint(uintVal)
+	case reflect.Float32, reflect.Float64:
+		floatVal, err := parseFloat(v, value.Type())
+		if err != nil {
+			return lexer.Errorf(v.Pos(), "field %s: %w", field, err)
+		}
+		value.SetFloat(floatVal)
+	case reflect.String:
+		switch v := v.(type) {
+		case *parser.StringLit:
+			value.SetString(v.Value)
+		case *parser.IntLit:
+			if !d.coerce {
+				return lexer.Errorf(v.Pos(), "field %s: expected string, got int", field)
+			}
+			value.SetString(v.Literal)
+		default:
+			return lexer.Errorf(v.Pos(), "field %s: expected string, got %s", field, valueKind(v))
+		}
+	case reflect.Bool:
+		b, ok := v.(*parser.BoolLit)
+		if !ok {
+			return lexer.Errorf(v.Pos(), "field %s: expected bool, got %s", field, valueKind(v))
+		}
+		value.SetBool(b.Value)
+	case reflect.Slice:
+		arr, ok := v.(*parser.ArrayLit)
+		if !ok {
+			return lexer.Errorf(v.Pos(), "field %s: expected array, got %s", field, valueKind(v))
+		}
+
+		arrValue := reflect.MakeSlice(value.Type(), len(arr.Elems), len(arr.Elems))
+		for idx, elem := range arr.Elems {
+			err := d.setValue(arrValue.Index(idx), elem, field, recLevel)
+			if err != nil {
+				return err
 			}
-			value.SetString(v)
-		case reflect.Bool:
-			v, ok := parsed[tag].(bool)
+		}
+		value.Set(arrValue)
+	case reflect.Struct:
+		if !isPair(value.Type()) {
+			lit, ok := v.(*parser.SectionLit)
 			if !ok {
-				return fmt.Errorf("field %s: expected bool, got %T", field.Name, parsed[tag])
+				return lexer.Errorf(v.Pos(), "field %s: expected section, got %s", field, valueKind(v))
 			}
-			value.SetBool(v)
-		case reflect.Slice:
-			arrType := value.Type().Elem().Kind()
+			if recLevel >= d.maxDepth {
+				return d.nestingError(v.Pos(), field)
+			}
+			return d.fillStruct(value, lit.Body, lit.Pos(), recLevel+1)
+		}
 
-			switch arrType {
-			case reflect.Struct:
-				v, ok := parsed[tag].([]map[string]any)
-				if !ok {
-					return fmt.Errorf("field %s: wanted map[string]any, got %T", field.Name, parsed[tag])
-				}
+		p, ok := v.(*parser.PairLit)
+		if !ok {
+			return lexer.Errorf(v.Pos(), "field %s: expected pair, got %s", field, valueKind(v))
+		}
 
-				elemType := value.Type().Elem()
-				arrValue := reflect.MakeSlice(value.Type(), len(v), len(v))
+		err := d.setValue(value.FieldByName("First"), p.First, field, recLevel)
+		if err != nil {
+			return err
+		}
+		err = d.setValue(value.FieldByName("Second"), p.Second, field, recLevel)
+		if err != nil {
+			return err
+		}
+	case reflect.Interface:
+		if value.NumMethod() != 0 {
+			return lexer.Errorf(v.Pos(), "field %s: not accepted value", field)
+		}
+		if _, ok := v.(*parser.NilLit); ok {
+			value.SetZero()
+			return nil
+		}
 
-				if recLevel >= 1 {
-					return errors.New("nesting past 1 level not allowed")
-				}
+		t, ok := anyType(v)
+		if !ok {
+			return lexer.Errorf(v.Pos(), "field %s: %s can't be decoded into %s", field, valueKind(v), value.Type())
+		}
+		elem := reflect.New(t).Elem()
+		err := d.setValue(elem, v, field, recLevel)
+		if err != nil {
+			return err
+		}
+		value.Set(elem)
+	default:
+		return lexer.Errorf(v.Pos(), "field %s: not accepted value", field)
+	}
 
-				for idx := range len(v) {
-					structValues := v[idx]
-					newElem := reflect.New(elemType).Elem()
-					err := fillStruct(newElem, structValues, recLevel+1)
-					if err != nil {
-						return err
-					}
-					arrValue.Index(idx).Set(newElem)
-				}
+	return nil
+}
 
-				value.Set(arrValue)
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				v, ok := parsed[tag].([]any)
-				if !ok {
-					return fmt.Errorf("field %s: wanted []any, got %T", field.Name, parsed[tag])
-				}
-				bits := value.Type().Elem().Bits()
-				arrValue := reflect.MakeSlice(value.Type(), len(v), len(v))
-
-				for idx := range len(v) {
-					str, ok := v[idx].(string)
-					if !ok {
-						return fmt.Errorf("field %s: wanted str as part of []any, got %T", field.Name, v[idx])
-					}
-					intVal, err := strconv.ParseInt(str, 10, bits)
-					if err != nil {
-						return err
-					}
-
-					arrValue.Index(idx).SetInt(intVal)
-				}
-				value.Set(arrValue)
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				v, ok := parsed[tag].([]any)
-				if !ok {
-					return fmt.Errorf("field %s: wanted []any, got %T", field.Name, parsed[tag])
-				}
-				bits := value.Type().Elem().Bits()
-				arrValue := reflect.MakeSlice(value.Type(), len(v), len(v))
-
-				for idx := range len(v) {
-					str, ok := v[idx].(string)
-					if !ok {
-						return fmt.Errorf("field %s: wanted str as part of []any, got %T", field.Name, v[idx])
-					}
-					intVal, err := strconv.ParseUint(str, 10, bits)
-					if err != nil {
-						return err
-					}
-
-					arrValue.Index(idx).SetUint(intVal)
-				}
-				value.Set(arrValue)
-			default:
-				v, ok := parsed[tag].([]any)
-				if !ok {
-					return fmt.Errorf("field %s: wanted []any, got %T", field.Name, parsed[tag])
-				}
+// anyType returns the type v is decoded as when the field it goes into is an interface.
+func anyType(v parser.Value) (reflect.Type, bool) {
+	switch v.(type) {
+	case *parser.IntLit:
+		return reflect.TypeFor[int64](), true
+	case *parser.FloatLit:
+		return reflect.TypeFor[float64](), true
+	case *parser.StringLit:
+		return reflect.TypeFor[string](), true
+	case *parser.BoolLit:
+		return reflect.TypeFor[bool](), true
+	case *parser.ArrayLit:
+		return reflect.TypeFor[[]any](), true
+	case *parser.PairLit:
+		return reflect.TypeFor[pair.Pair[any, any]](), true
+	default:
+		return nil, false
+	}
+}
 
-				elemType := value.Type().Elem()
-				arrValue := reflect.MakeSlice(value.Type(), len(v), len(v))
+func (d *decodeState) nestingError(pos lexer.Position, field string) error {
+	if d.maxDepth == 1 {
+		return lexer.Errorf(pos, "field %s: nesting past 1 level not allowed", field)
+	}
+	return lexer.Errorf(pos, "field %s: nesting past %d levels not allowed", field, d.maxDepth)
+}
 
-				for idx, item := range v {
-					itemVal := reflect.ValueOf(item)
-					if !itemVal.Type().ConvertibleTo(elemType) {
-						return fmt.Errorf("field %s: wanted %v as part of [], got %T", field.Name, elemType, v[idx])
-					}
-					arrValue.Index(idx).Set(itemVal.Convert(elemType))
-				}
-				value.Set(arrValue)
-			}
+func isPair(t reflect.Type) bool {
+	return t.PkgPath() == "github.com/grian32/gcfg/pair" && strings.HasPrefix(t.Name(), "Pair[")
+}
 
-		case reflect.Struct:
-			currType := field.Type
+// isSection reports whether t is decoded from a section rather than a value.
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !isPair(t)
+}
 
-			if currType.PkgPath() == "github.com/grian32/gcfg/pair" && strings.HasPrefix(currType.Name(), "Pair[") {
-				p, ok := parsed[tag].(pair.Pair[any, any])
-				if !ok {
-					return fmt.Errorf("field %s: expected pair.Pair[any, any], got %T", field.Name, p)
-				}
+func isAssignment(decl parser.Decl) bool {
+	_, ok := decl.(*parser.Assignment)
+	return ok
+}
 
-				structValues := map[string]any{
-					"First":  p.First,
-					"Second": p.Second,
-				}
+// sectionBody returns the body of a block section, or of an inline section assigned to a key.
+func sectionBody(decl parser.Decl) ([]parser.Decl, bool) {
+	switch decl := decl.(type) {
+	case *parser.Section:
+		return decl.Body, true
+	case *parser.Assignment:
+		lit, ok := decl.Value.(*parser.SectionLit)
+		if !ok {
+			return nil, false
+		}
+		return lit.Body, true
+	default:
+		return nil, false
+	}
+}
 
-				err := fillStruct(value, structValues, recLevel+1)
-				if err != nil {
-					return err
-				}
-			} else {
-				if recLevel >= 1 {
-					return errors.New("nesting past 1 level not allowed")
-				}
+func declKind(decl parser.Decl) string {
+	switch decl.(type) {
+	case *parser.Assignment:
+		return "value"
+	case *parser.Section:
+		return "section"
+	case *parser.SectionArray:
+		return "section array"
+	default:
+		return fmt.Sprintf("%T", decl)
+	}
+}
 
-				structValues, ok := parsed[tag].(map[string]any)
-				if !ok {
-					return errors.New("bad input for nested struct")
-				}
+func valueKind(v parser.Value) string {
+	switch v.(type) {
+	case *parser.IntLit:
+		return "int"
+	case *parser.FloatLit:
+		return "float"
+	case *parser.StringLit:
+		return "string"
+	case *parser.BoolLit:
+		return "bool"
+	case *parser.NilLit:
+		return "nil"
+	case *parser.ArrayLit:
+		return "array"
+	case *parser.PairLit:
+		return "pair"
+	case *parser.SectionLit:
+		return "section"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
 
-				err := fillStruct(value, structValues, recLevel+1)
-				if err != nil {
-					return err
-				}
-			}
-		default:
-			return errors.New("not accepted value")
+// intLiteral returns the text of an integer. Strings are only let through when coercing, as integers used to be parsed
+// into them.
+func (d *decodeState) intLiteral(v parser.Value) (string, bool) {
+	switch v := v.(type) {
+	case *parser.IntLit:
+		return v.Literal, true
+	case *parser.StringLit:
+		return v.Value, d.coerce
+	default:
+		return "", false
+	}
+}
+
+// splitIntLiteral strips the sign, base prefix and _ separators from an integer literal, returning the remaining
+// digits with the sign put back, and the base they are in.
+func splitIntLiteral(literal string) (string, int) {
+	sign := ""
+	digits := literal
+	if strings.HasPrefix(digits, "-") {
+		sign = "-"
+		digits = digits[1:]
+	} else if strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] | 0x20 {
+		case 'x':
+			base = 16
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+		if base != 10 {
+			digits = digits[2:]
 		}
 	}
 
-	return nil
+	return sign + strings.ReplaceAll(digits, "_", ""), base
+}
+
+// parseInt parses an integer literal into a value that fits in bits, errors quote the literal as it was written.
+func parseInt(literal string, bits int) (int64, error) {
+	digits, base := splitIntLiteral(literal)
+	val, err := strconv.ParseInt(digits, base, bits)
+	return val, literalNumError(err, literal)
+}
+
+func parseUint(literal string, bits int) (uint64, error) {
+	digits, base := splitIntLiteral(literal)
+	val, err := strconv.ParseUint(digits, base, bits)
+	return val, literalNumError(err, literal)
+}
+
+// parseFloat converts a float, or an integer, into a value that fits in t without overflowing it. inf and nan are let
+// through as they are.
+func parseFloat(v parser.Value, t reflect.Type) (float64, error) {
+	var floatVal float64
+
+	switch v := v.(type) {
+	case *parser.FloatLit:
+		floatVal = v.Value
+	case *parser.IntLit:
+		intVal, err := parseInt(v.Literal, 64)
+		if err != nil {
+			return 0, err
+		}
+		floatVal = float64(intVal)
+	default:
+		return 0, fmt.Errorf("expected float, got %s", valueKind(v))
+	}
+
+	if reflect.Zero(t).OverflowFloat(floatVal) {
+		return 0, fmt.Errorf("%v overflows %v", floatVal, t)
+	}
+
+	return floatVal, nil
+}
+
+func literalNumError(err error, literal string) error {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		numErr.Num = literal
+	}
+	return err
 }
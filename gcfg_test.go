@@ -1,8 +1,11 @@
 package gcfg
 
 import (
+	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/grian32/gcfg/pair"
 )
@@ -26,6 +29,7 @@ type Point struct {
 	Name       string                   `gcfg:"name"`
 	Underscore string                   `gcfg:"und_erscore"`
 	Multiline  string                   `gcfg:"multiline"`
+	Dedented   string                   `gcfg:"dedented"`
 }
 
 type SecArr struct {
@@ -50,6 +54,10 @@ Point {
 	hi
 hello
 "
+	dedented = """
+		SELECT *
+		FROM t
+	"""
 }
 
 set = true
@@ -76,6 +84,7 @@ set = true
 			Name:       "hello",
 			Underscore: "hi",
 			Multiline:  "\n\thi\nhello\n",
+			Dedented:   "SELECT *\nFROM t\n",
 		},
 		Set: true,
 		SecArr: []SecArr{
@@ -95,3 +104,707 @@ set = true
 		t.Errorf("Unmarshal=%v, %v want match for %v", cfg, err, expectedCfg)
 	}
 }
+
+type PosConfig struct {
+	Pos PosSection `gcfg:"Pos"`
+}
+
+type PosSection struct {
+	Z uint8   `gcfg:"z"`
+	S []int32 `gcfg:"s"`
+}
+
+func TestDecoder(t *testing.T) {
+	input := `
+Point {
+	x = 3
+	y = 1
+	z = 4
+	s = [1, 2]
+	l = []
+	empty = []
+	single = ["hi"]
+	ab = (1, "hi")
+	h = []
+	name = "hello"
+	und_erscore = "hi"
+	multiline = "hi"
+	dedented = "hi"
+}
+
+set = true
+
+[SecArr] {
+	foo = 3
+}
+`
+	var expectedCfg Config
+	err := Unmarshal([]byte(input), &expectedCfg)
+	if err != nil {
+		t.Fatalf("Unmarshal=%v", err)
+	}
+
+	var cfg Config
+	err = NewDecoder(strings.NewReader(input)).Decode(&cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expectedCfg) {
+		t.Errorf("Decode=%v, %v want match for %v", cfg, err, expectedCfg)
+	}
+}
+
+func TestDecoderErrorPosition(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("Pos {\n\tz = 1\n\ts = [1 2]\n}"))
+	dec.SetFilename("config.gcfg")
+
+	var cfg PosConfig
+	err := dec.Decode(&cfg)
+
+	expected := "config.gcfg:3:9: expected comma after value in array"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Decode error=%v, wanted %s", err, expected)
+	}
+}
+
+func TestDecoderAllowDuplicates(t *testing.T) {
+	input := `
+Pos {
+	z = 1
+	s = []
+	z = 2
+}
+`
+
+	var cfg PosConfig
+	err := Unmarshal([]byte(input), &cfg)
+	if err == nil {
+		t.Errorf("Unmarshal error=nil, wanted duplicate key error")
+	}
+
+	expectedCfg := PosConfig{Pos: PosSection{Z: 2, S: []int32{}}}
+
+	cfg = PosConfig{}
+	dec := NewDecoder(strings.NewReader(input))
+	dec.AllowDuplicates()
+	err = dec.Decode(&cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expectedCfg) {
+		t.Errorf("Decode=%v, %v want match for %v", cfg, err, expectedCfg)
+	}
+}
+
+func TestDecoderAllowCoercion(t *testing.T) {
+	type coerceConfig struct {
+		Port int32  `gcfg:"port"`
+		Name string `gcfg:"name"`
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		coerced  coerceConfig
+	}{
+		{
+			name:     "StringIntoInt",
+			input:    "port = \"8080\"\nname = \"x\"",
+			expected: "config.gcfg:1:8: field Port: expected int, got string",
+			coerced:  coerceConfig{Port: 8080, Name: "x"},
+		},
+		{
+			name:     "IntIntoString",
+			input:    "port = 8080\nname = 42",
+			expected: "config.gcfg:2:8: field Name: expected string, got int",
+			coerced:  coerceConfig{Port: 8080, Name: "42"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg coerceConfig
+			err := UnmarshalNamed("config.gcfg", []byte(tt.input), &cfg)
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("UnmarshalNamed error=%v, wanted %s", err, tt.expected)
+			}
+
+			cfg = coerceConfig{}
+			dec := NewDecoder(strings.NewReader(tt.input))
+			dec.AllowCoercion()
+			err = dec.Decode(&cfg)
+			if err != nil || cfg != tt.coerced {
+				t.Errorf("Decode=%v, %v want %v", cfg, err, tt.coerced)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Parser",
+			input:    "Pos {\n\ts = [1, 2 3]\n}",
+			expected: "config.gcfg:2:12: expected comma after value in array",
+		},
+		{
+			name:     "Range",
+			input:    "Pos {\n\tz = 300\n\ts = []\n}",
+			expected: `config.gcfg:2:6: field Z: strconv.ParseUint: parsing "300": value out of range`,
+		},
+		{
+			name:     "ArrayElement",
+			input:    "Pos {\n\tz = 1\n\ts = [1,\n\t\t99999999999]\n}",
+			expected: `config.gcfg:4:3: field S: strconv.ParseInt: parsing "99999999999": value out of range`,
+		},
+		{
+			name:     "Missing",
+			input:    "Pos {\n\ts = []\n}",
+			expected: "config.gcfg:1:1: field Z: key z not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg PosConfig
+			err := UnmarshalNamed("config.gcfg", []byte(tt.input), &cfg)
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("UnmarshalNamed error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+type IntLiteralConfig struct {
+	Mode    uint32  `gcfg:"mode"`
+	Mask    uint16  `gcfg:"mask"`
+	Flags   int8    `gcfg:"flags"`
+	Limit   int64   `gcfg:"limit"`
+	Neg     int32   `gcfg:"neg"`
+	Bitmaps []uint8 `gcfg:"bitmaps"`
+	Offsets []int16 `gcfg:"offsets"`
+}
+
+func TestUnmarshalIntLiterals(t *testing.T) {
+	input := `
+mode = 0o644
+mask = 0xFF00
+flags = 0b0101
+limit = 10_000_000
+neg = -0x10
+bitmaps = [0b1111_0000, 0xff, 7]
+offsets = [-0o10, 1_000]
+`
+
+	expectedCfg := IntLiteralConfig{
+		Mode:    0o644,
+		Mask:    0xFF00,
+		Flags:   0b0101,
+		Limit:   10_000_000,
+		Neg:     -0x10,
+		Bitmaps: []uint8{0b1111_0000, 0xff, 7},
+		Offsets: []int16{-0o10, 1_000},
+	}
+
+	var cfg IntLiteralConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expectedCfg) {
+		t.Errorf("Unmarshal=%v, %v want match for %v", cfg, err, expectedCfg)
+	}
+}
+
+func TestUnmarshalIntLiteralRange(t *testing.T) {
+	type rangeConfig struct {
+		Small uint8 `gcfg:"small"`
+	}
+
+	var cfg rangeConfig
+	err := UnmarshalNamed("config.gcfg", []byte("small = 0x1_00"), &cfg)
+
+	expected := `config.gcfg:1:9: field Small: strconv.ParseUint: parsing "0x1_00": value out of range`
+	if err == nil || err.Error() != expected {
+		t.Errorf("UnmarshalNamed error=%v, wanted %s", err, expected)
+	}
+}
+
+type FloatConfig struct {
+	Small   float32   `gcfg:"small"`
+	Big     float64   `gcfg:"big"`
+	Whole   float32   `gcfg:"whole"`
+	Plus    int32     `gcfg:"plus"`
+	Inf     float32   `gcfg:"pos_inf"`
+	NegInf  float64   `gcfg:"neg_inf"`
+	Nan     float64   `gcfg:"not_a_number"`
+	Weights []float32 `gcfg:"weights"`
+}
+
+func TestUnmarshalFloats(t *testing.T) {
+	input := `
+small = 1e-6
+big = 6.02e23
+whole = 3
+plus = +3
+pos_inf = inf
+neg_inf = -inf
+not_a_number = nan
+weights = [0.5, 2.5e2, -1E-1]
+`
+
+	expectedCfg := FloatConfig{
+		Small:   1e-6,
+		Big:     6.02e23,
+		Whole:   3,
+		Plus:    3,
+		Inf:     float32(math.Inf(1)),
+		NegInf:  math.Inf(-1),
+		Weights: []float32{0.5, 2.5e2, -1e-1},
+	}
+
+	var cfg FloatConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if !math.IsNaN(cfg.Nan) {
+		t.Errorf("Unmarshal nan=%v, wanted NaN", cfg.Nan)
+	}
+	cfg.Nan = 0
+
+	if err != nil || !reflect.DeepEqual(cfg, expectedCfg) {
+		t.Errorf("Unmarshal=%v, %v want match for %v", cfg, err, expectedCfg)
+	}
+}
+
+func TestUnmarshalSignedNan(t *testing.T) {
+	for _, input := range []string{"value = -nan", "value = +nan"} {
+		var cfg struct {
+			Nan float64 `gcfg:"value"`
+		}
+		err := Unmarshal([]byte(input), &cfg)
+
+		if err != nil || !math.IsNaN(cfg.Nan) {
+			t.Errorf("Unmarshal(%q)=%v, %v, wanted NaN", input, cfg.Nan, err)
+		}
+	}
+}
+
+func TestUnmarshalFloatOverflow(t *testing.T) {
+	type overflowConfig struct {
+		F float32 `gcfg:"f"`
+	}
+
+	var cfg overflowConfig
+	err := UnmarshalNamed("config.gcfg", []byte("f = 1e39"), &cfg)
+
+	expected := "config.gcfg:1:5: field F: 1e+39 overflows float32"
+	if err == nil || err.Error() != expected {
+		t.Errorf("UnmarshalNamed error=%v, wanted %s", err, expected)
+	}
+}
+
+func TestUnmarshalCompoundArrays(t *testing.T) {
+	type compoundConfig struct {
+		Routes []pair.Pair[string, int32] `gcfg:"routes"`
+		Matrix [][]int                    `gcfg:"matrix"`
+	}
+
+	input := `
+routes = [("/a", 1), ("/b", 2)]
+matrix = [[1, 2], [3, 4], []]
+`
+
+	expected := compoundConfig{
+		Routes: []pair.Pair[string, int32]{{First: "/a", Second: 1}, {First: "/b", Second: 2}},
+		Matrix: [][]int{{1, 2}, {3, 4}, {}},
+	}
+
+	var cfg compoundConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unmarshal=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+}
+
+func TestUnmarshalCompoundPairs(t *testing.T) {
+	type compoundConfig struct {
+		Named pair.Pair[string, []int]                            `gcfg:"named"`
+		Line  pair.Pair[pair.Pair[int, int], pair.Pair[int, int]] `gcfg:"line"`
+	}
+
+	input := `
+named = ("name", [1, 2])
+line = ((1, 2), (3, 4))
+`
+
+	expected := compoundConfig{
+		Named: pair.Pair[string, []int]{First: "name", Second: []int{1, 2}},
+		Line: pair.Pair[pair.Pair[int, int], pair.Pair[int, int]]{
+			First:  pair.Pair[int, int]{First: 1, Second: 2},
+			Second: pair.Pair[int, int]{First: 3, Second: 4},
+		},
+	}
+
+	var cfg compoundConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unmarshal=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+}
+
+func TestUnmarshalInterfaces(t *testing.T) {
+	type anyConfig struct {
+		Names  []any `gcfg:"names"`
+		Mixed  []any `gcfg:"mixed"`
+		Single any   `gcfg:"single"`
+		Empty  any   `gcfg:"empty"`
+	}
+
+	input := `
+names = ["x", "y"]
+mixed = [[1, 2], []]
+single = ("a", 1.5)
+empty = nil
+`
+
+	expected := anyConfig{
+		Names:  []any{"x", "y"},
+		Mixed:  []any{[]any{int64(1), int64(2)}, []any{}},
+		Single: pair.Pair[any, any]{First: "a", Second: 1.5},
+	}
+
+	var cfg anyConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unmarshal=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+
+	var sectionCfg struct {
+		Single any `gcfg:"single"`
+	}
+	err = Unmarshal([]byte("single = { a = 1 }"), &sectionCfg)
+
+	expectedErr := "1:10: field Single: section can't be decoded into interface {}"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("Unmarshal error=%v, wanted %s", err, expectedErr)
+	}
+}
+
+func TestUnmarshalSectionLit(t *testing.T) {
+	type origin struct {
+		X int32 `gcfg:"x"`
+		Y int32 `gcfg:"y"`
+	}
+	type sectionLitConfig struct {
+		Origin origin   `gcfg:"origin"`
+		Points []origin `gcfg:"points"`
+		Block  origin   `gcfg:"Block"`
+	}
+
+	input := `
+origin = { x = 1, y = 2 }
+points = [{ x = 3, y = 4 }, { x = 5, y = 6 }]
+Block {
+	x = 7
+	y = 8
+}
+`
+
+	expected := sectionLitConfig{
+		Origin: origin{X: 1, Y: 2},
+		Points: []origin{{X: 3, Y: 4}, {X: 5, Y: 6}},
+		Block:  origin{X: 7, Y: 8},
+	}
+
+	var cfg sectionLitConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unmarshal=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Missing",
+			input:    "origin = { x = 1 }\npoints = []\nBlock {\n\tx = 1\n\ty = 2\n}",
+			expected: "config.gcfg:1:1: field Y: key y not found",
+		},
+		{
+			name:     "NotSection",
+			input:    "origin = 1",
+			expected: "config.gcfg:1:1: field Origin: expected section, got value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg sectionLitConfig
+			err := UnmarshalNamed("config.gcfg", []byte(tt.input), &cfg)
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("UnmarshalNamed error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSectionLitNesting(t *testing.T) {
+	type inner struct {
+		A int32 `gcfg:"a"`
+	}
+	type outer struct {
+		Inner inner `gcfg:"inner"`
+	}
+	type nestingConfig struct {
+		Outer outer `gcfg:"outer"`
+	}
+
+	var cfg nestingConfig
+	err := UnmarshalNamed("config.gcfg", []byte("outer = { inner = { a = 1 } }"), &cfg)
+
+	expected := "config.gcfg:1:11: field Inner: nesting past 1 level not allowed"
+	if err == nil || err.Error() != expected {
+		t.Errorf("UnmarshalNamed error=%v, wanted %s", err, expected)
+	}
+}
+
+func TestDecoderMaxDepth(t *testing.T) {
+	type route struct {
+		Path string `gcfg:"path"`
+	}
+	type server struct {
+		Host   string  `gcfg:"host"`
+		Routes []route `gcfg:"Route"`
+	}
+	type depthConfig struct {
+		Servers []server `gcfg:"Server"`
+	}
+
+	input := `
+[Server] {
+	host = "a"
+	[Route] {
+		path = "/a"
+	}
+	[Route] {
+		path = "/b"
+	}
+}
+`
+
+	expected := depthConfig{
+		Servers: []server{{Host: "a", Routes: []route{{Path: "/a"}, {Path: "/b"}}}},
+	}
+
+	var cfg depthConfig
+	dec := NewDecoder(strings.NewReader(input))
+	dec.SetMaxDepth(2)
+	err := dec.Decode(&cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Decode=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+
+	// inline sections count towards the depth too
+	cfg = depthConfig{}
+	dec = NewDecoder(strings.NewReader(`[Server] {
+	host = "a"
+	Route = [{ path = "/a" }]
+}`))
+	dec.SetFilename("config.gcfg")
+	err = dec.Decode(&cfg)
+
+	expectedErr := "config.gcfg:3:11: field Routes: nesting past 1 level not allowed"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("Decode error=%v, wanted %s", err, expectedErr)
+	}
+}
+
+func TestUnmarshalInheritance(t *testing.T) {
+	type server struct {
+		Host string `gcfg:"host"`
+		Port int32  `gcfg:"port"`
+	}
+	type inheritConfig struct {
+		Prod    server   `gcfg:"Prod"`
+		Staging server   `gcfg:"Staging"`
+		Workers []server `gcfg:"Worker"`
+	}
+
+	input := `
+Defaults {
+	host = "localhost"
+	port = 80
+}
+
+Prod : Defaults {
+	host = "prod.example.com"
+	port = 443
+}
+
+Staging : Defaults {
+	host = "staging.example.com"
+}
+
+[Worker] : Defaults {
+	port = 8081
+}
+`
+
+	expected := inheritConfig{
+		Prod:    server{Host: "prod.example.com", Port: 443},
+		Staging: server{Host: "staging.example.com", Port: 80},
+		Workers: []server{{Host: "localhost", Port: 8081}},
+	}
+
+	var cfg inheritConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unmarshal=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+}
+
+func TestDecoderMergeSections(t *testing.T) {
+	type logging struct {
+		Level string `gcfg:"level"`
+		File  string `gcfg:"file"`
+	}
+	type mergeConfig struct {
+		Logging logging `gcfg:"Logging"`
+	}
+
+	input := `
+Logging {
+	level = "info"
+}
+
+Logging {
+	file = "x"
+}
+`
+
+	var cfg mergeConfig
+	err := Unmarshal([]byte(input), &cfg)
+	if err == nil {
+		t.Errorf("Unmarshal error=nil, wanted duplicate section error")
+	}
+
+	expected := mergeConfig{Logging: logging{Level: "info", File: "x"}}
+
+	cfg = mergeConfig{}
+	dec := NewDecoder(strings.NewReader(input))
+	dec.MergeSections()
+	err = dec.Decode(&cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Decode=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+}
+
+func TestUnmarshalDottedKeys(t *testing.T) {
+	type server struct {
+		Host string `gcfg:"host"`
+		Port int32  `gcfg:"port"`
+	}
+	type dottedConfig struct {
+		Server server `gcfg:"Server"`
+	}
+
+	input := `
+Server {
+	host = "a"
+}
+Server.port = 8080
+`
+
+	expected := dottedConfig{Server: server{Host: "a", Port: 8080}}
+
+	var cfg dottedConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unmarshal=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+}
+
+func TestUnmarshalFS(t *testing.T) {
+	type db struct {
+		Host string `gcfg:"host"`
+		Port int32  `gcfg:"port"`
+	}
+	type fsConfig struct {
+		Name string `gcfg:"name"`
+		Db   db     `gcfg:"Db"`
+	}
+
+	fsys := fstest.MapFS{
+		"conf/base.gcfg":       {Data: []byte("name = \"app\"\n@include \"db.gcfg\"\n@include \"local/*.gcfg\"")},
+		"conf/db.gcfg":         {Data: []byte("Db {\n\thost = \"localhost\"\n}")},
+		"conf/local/port.gcfg": {Data: []byte("Db.port = 5432")},
+	}
+
+	expected := fsConfig{Name: "app", Db: db{Host: "localhost", Port: 5432}}
+
+	var cfg fsConfig
+	err := UnmarshalFS(fsys, "conf/base.gcfg", &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("UnmarshalFS=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+
+	err = Unmarshal(fsys["conf/base.gcfg"].Data, &cfg)
+
+	expectedErr := `2:1: @include "db.gcfg": no file system to include from`
+	if err == nil || !strings.HasPrefix(err.Error(), expectedErr) {
+		t.Errorf("Unmarshal error=%v, wanted %s", err, expectedErr)
+	}
+}
+
+func TestUnmarshalReferences(t *testing.T) {
+	type server struct {
+		Host string `gcfg:"host"`
+		Port int32  `gcfg:"port"`
+	}
+	type refConfig struct {
+		Url     string   `gcfg:"url"`
+		Port    int32    `gcfg:"port"`
+		Mirrors []string `gcfg:"mirrors"`
+		Servers []server `gcfg:"Server"`
+	}
+
+	input := `url = "http://${Server[0].host}:${Server[0].port}"
+port = ${Server[1].port}
+mirrors = [${Server[1].host}, "${Server[0].host}"]
+
+[Server] {
+	host = "a.example.com"
+	port = 80
+}
+
+[Server] {
+	host = "b.example.com"
+	port = 8080
+}`
+
+	expected := refConfig{
+		Url:     "http://a.example.com:80",
+		Port:    8080,
+		Mirrors: []string{"b.example.com", "a.example.com"},
+		Servers: []server{{"a.example.com", 80}, {"b.example.com", 8080}},
+	}
+
+	var cfg refConfig
+	err := Unmarshal([]byte(input), &cfg)
+
+	if err != nil || !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unmarshal=%v, %v, wanted match for %v", cfg, err, expected)
+	}
+}
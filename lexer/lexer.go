@@ -1,15 +1,54 @@
 package lexer
 
-import "errors"
+import (
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// Mode controls optional lexer behaviour, modes can be combined with |.
+type Mode uint
+
+const (
+	// ScanComments makes NextToken return comments as COMMENT tokens instead of skipping them, so tools such as
+	// formatters can keep them around.
+	ScanComments Mode = 1 << iota
+	// RecoverErrors makes NextToken carry on past malformed input. Instead of returning an error it returns an ILLEGAL
+	// token covering the input up to the next whitespace or delimiter, and records the error in Errors.
+	RecoverErrors
+)
 
 type Lexer struct {
+	// input is the whole input for lexers made by New, and a sliding window over the reader for ones made by
+	// NewReader, with offset being how far into the reader the window starts.
 	input   []byte
+	offset  int
 	readPos int
 	pos     int
 	ch      byte
+	// eof is set once the input has run out, ch is 0 then. A 0 byte in the input isn't the end, just an illegal
+	// character.
+	eof  bool
+	mode Mode
+
+	reader  io.Reader
+	readErr error
+
+	// tokStart and tokPos are where the token being scanned starts, so RecoverErrors can turn it into an ILLEGAL token
+	tokStart int
+	tokPos   Position
+	errors   ErrorList
+
+	// scratch holds decoded string contents and lines the lines of a multiline string, both are reused across tokens
+	scratch []byte
+	lines   []lineSpan
+
+	filename string
+	line     int
+	col      int
 }
 
-var singleCharTokens = map[byte]TokenType{
+var singleCharTokens = [256]TokenType{
 	'[': LBRACKET,
 	']': RBRACKET,
 	'(': LPAREN,
@@ -18,23 +57,51 @@ var singleCharTokens = map[byte]TokenType{
 	'}': RBRACE,
 	'=': ASSIGN,
 	',': COMMA,
+	':': COLON,
+	'.': DOT,
 }
 
 var keywordToken = map[string]TokenType{
 	"true":  TRUE,
 	"false": FALSE,
 	"nil":   NULL,
+	"inf":   FLOAT,
+	"nan":   FLOAT,
 }
 
 func New(input []byte) *Lexer {
-	l := &Lexer{input: input}
+	return NewNamed("", input)
+}
+
+// NewNamed creates a lexer whose token positions, and therefore errors, carry filename.
+func NewNamed(filename string, input []byte) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.advance()
 	return l
 }
 
+func (l *Lexer) Filename() string {
+	return l.filename
+}
+
+func (l *Lexer) Mode() Mode {
+	return l.mode
+}
+
+func (l *Lexer) SetMode(mode Mode) {
+	l.mode = mode
+}
+
 func (l *Lexer) advance() {
-	if l.readPos >= len(l.input) {
+	if l.ch == '\n' {
+		l.line += 1
+		l.col = 0
+	}
+	l.col += 1
+
+	if l.readPos >= len(l.input) && !l.fill() {
 		l.ch = 0
+		l.eof = true
 	} else {
 		l.ch = l.input[l.readPos]
 	}
@@ -44,101 +111,420 @@ func (l *Lexer) advance() {
 }
 
 func (l *Lexer) NextToken() (Token, error) {
+	tok, err := l.nextToken()
+	// a failed read looks like the end of the input to the scanning code, so whatever it made of that is misleading
+	if l.readErr != nil {
+		return Token{}, Errorf(l.position(), "%w", l.readErr)
+	}
+
+	if err != nil && l.mode&RecoverErrors != 0 {
+		tok = l.recover(err)
+		err = nil
+	}
+
+	tok.End = l.position()
+	return tok, err
+}
+
+// Errors returns the errors skipped over so far in RecoverErrors mode.
+func (l *Lexer) Errors() ErrorList {
+	return l.errors
+}
+
+// recover records err and skips ahead to the next whitespace or delimiter, returning everything from the start of the
+// broken token up to there as an ILLEGAL token.
+func (l *Lexer) recover(err error) Token {
+	var posErr *Error
+	if !errors.As(err, &posErr) {
+		posErr = &Error{Pos: l.tokPos, Err: err}
+	}
+	l.errors.Add(posErr)
+
+	// always move on by at least a character so a token that fails without consuming anything can't loop forever
+	if l.pos == l.tokStart && !l.eof {
+		l.advance()
+	}
+	// a string that goes wrong part way through is skipped up to its closing quote, otherwise the rest of it would be
+	// read as tokens and the closing quote would open a new string. Multiline strings only fail part way through at
+	// the end of the input, and raw strings only fail there.
+	start := l.input[l.tokStart:l.pos]
+	if len(start) > 0 && start[0] == '"' && !(len(start) >= 3 && string(start[:3]) == `"""`) {
+		for !l.eof && l.ch != '"' {
+			if l.ch == '\\' {
+				l.advance() // an escaped quote doesn't close the string
+			}
+			l.advance()
+		}
+		if !l.eof {
+			l.advance() // go past "
+		}
+	}
+	for !l.eof && charClass[l.ch]&(classSpace|classPunct) == 0 {
+		l.advance()
+	}
+
+	return Token{Type: ILLEGAL, Value: l.input[l.tokStart:l.pos], Pos: l.tokPos}
+}
+
+// startToken marks the current character as the start of a token.
+func (l *Lexer) startToken() {
+	l.discard()
+	l.tokStart = l.pos
+	l.tokPos = l.position()
+}
+
+func (l *Lexer) nextToken() (Token, error) {
 	// TODO: consider not doing this.. maybe just for indentation
 	l.skipWhitespace()
 
-	singleTok, exists := singleCharTokens[l.ch]
-	if exists {
-		tok := newSingleToken(singleTok, l.ch)
+	for l.ch == '#' || l.ch == '/' {
+		l.startToken()
+		tok, err := l.readComment()
+		if err != nil {
+			return Token{}, err
+		}
+		if l.mode&ScanComments != 0 {
+			return tok, nil
+		}
+		l.skipWhitespace()
+	}
+
+	l.startToken()
+	pos := l.tokPos
+	class := charClass[l.ch]
+
+	// . isn't punctuation as far as skipping past a bad token goes, so 1.2.3 is one illegal token rather than three
+	if class&classPunct != 0 || l.ch == '.' {
+		tok := Token{Type: singleCharTokens[l.ch], Value: l.input[l.pos : l.pos+1], Pos: pos}
 		l.advance()
 		return tok, nil
 	}
 
-	if l.ch == 0 {
-		l.advance()
-		return Token{Type: EOF, Literal: ""}, nil
+	if l.eof {
+		// stay put so asking again past the end keeps giving the same EOF
+		return Token{Type: EOF, Pos: pos}, nil
 	} else if l.ch == '"' {
+		if l.peek() == '"' && l.peekAt(2) == '"' {
+			return l.readMultilineString()
+		}
 		return l.readString()
-	} else if IsDigit(l.ch) || l.ch == '-' {
+	} else if l.ch == '`' {
+		return l.readRawString()
+	} else if class&classDigit != 0 || l.ch == '-' || l.ch == '+' {
 		return l.readNumber()
-	} else {
+	} else if l.ch == '@' {
+		return l.readDirective()
+	} else if l.ch == '$' && l.peek() == '{' {
+		return l.readReference()
+	} else if class&classLetter != 0 {
+		return l.readIdent()
+	} else if r, _ := l.peekRune(); IsIdentStart(r) {
 		return l.readIdent()
+	} else {
+		return l.readIllegal()
 	}
 }
 
+func (l *Lexer) position() Position {
+	return Position{Filename: l.filename, Offset: l.offset + l.pos, Line: l.line, Column: l.col}
+}
+
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for charClass[l.ch]&classSpace != 0 {
+		l.discard()
 		l.advance()
 	}
 }
 
-func (l *Lexer) readIdent() (Token, error) {
+func (l *Lexer) peek() byte {
+	return l.peekAt(1)
+}
+
+// peekAt returns the byte n positions after the current one, or 0 past the end of the input.
+func (l *Lexer) peekAt(n int) byte {
+	for l.pos+n >= len(l.input) && l.fill() {
+	}
+	if l.pos+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+n]
+}
+
+// readComment reads a # or // comment up to the end of the line, or a /* */ block comment. The literal is the whole
+// comment including its delimiters, but without the newline ending a line comment.
+func (l *Lexer) readComment() (Token, error) {
+	pos := l.position()
 	startPos := l.pos
 
-	for IsLetter(l.ch) {
+	if l.ch == '/' {
+		switch l.peek() {
+		case '/':
+		case '*':
+			l.advance() // go past /
+			l.advance() // go past *
+			for l.ch != '*' || l.peek() != '/' {
+				if l.eof {
+					return Token{}, Errorf(pos, "unterminated block comment")
+				}
+				l.advance()
+			}
+			l.advance() // go past *
+			l.advance() // go past /
+			return Token{Type: COMMENT, Value: l.input[startPos:l.pos], Pos: pos}, nil
+		default:
+			return Token{}, Errorf(pos, "expected // or /* to start a comment")
+		}
+	}
+
+	for l.ch != '\n' && !l.eof {
 		l.advance()
 	}
 
-	literal := string(l.input[startPos:l.pos])
+	end := l.pos
+	if end > startPos && l.input[end-1] == '\r' {
+		end -= 1
+	}
 
-	keyword, exists := keywordToken[literal]
-	if exists {
-		return Token{Type: keyword, Literal: literal}, nil
+	return Token{Type: COMMENT, Value: l.input[startPos:end], Pos: pos}, nil
+}
+
+// peekRune decodes the utf8 character starting at the current byte, returning utf8.RuneError for invalid input.
+func (l *Lexer) peekRune() (rune, int) {
+	if l.ch < utf8.RuneSelf {
+		return rune(l.ch), 1
 	}
 
-	return Token{Type: IDENT, Literal: literal}, nil
+	l.peekAt(utf8.UTFMax - 1) // make sure the whole character has been read
+	if l.pos >= len(l.input) {
+		return 0, 0
+	}
+	return utf8.DecodeRune(l.input[l.pos:])
 }
 
-func (l *Lexer) readString() (Token, error) {
-	startPos := l.pos + 1
+func (l *Lexer) readIdent() (Token, error) {
+	pos := l.position()
+	startPos := l.pos
 
-	l.advance() // go past "
+	for {
+		if charClass[l.ch]&classIdent != 0 {
+			l.advance()
+			continue
+		}
+		if l.ch < utf8.RuneSelf {
+			break
+		}
 
-	for l.ch != '"' {
-		if l.ch == 0 {
-			return Token{}, errors.New("malformed string")
+		r, size := l.peekRune()
+		if !IsIdentPart(r) {
+			break
+		}
+		for range size {
+			l.advance()
+		}
+	}
+
+	literal := l.input[startPos:l.pos]
+
+	// the compiler turns a lookup keyed by string(literal) into one that doesn't allocate
+	keyword, exists := keywordToken[string(literal)]
+	if exists {
+		return Token{Type: keyword, Value: literal, Pos: pos}, nil
+	}
+
+	return Token{Type: IDENT, Value: literal, Pos: pos}, nil
+}
+
+// readReference reads ${path}, the parser works out what the path refers to.
+func (l *Lexer) readReference() (Token, error) {
+	pos := l.position()
+	startPos := l.pos
+
+	for l.ch != '}' {
+		if l.eof || l.ch == '\n' {
+			return Token{Type: ILLEGAL, Value: l.input[startPos:l.pos], Pos: pos}, Errorf(pos, "unterminated reference")
 		}
 		l.advance()
 	}
+	l.advance()
+
+	return Token{Type: REFERENCE, Value: l.input[startPos:l.pos], Pos: pos}, nil
+}
+
+// readDirective reads @ and the name after it, which the parser checks is a directive it knows. An @ without a name is
+// just an illegal character.
+func (l *Lexer) readDirective() (Token, error) {
+	l.peekAt(utf8.UTFMax) // load the whole first rune of the name
+	if r, _ := utf8.DecodeRune(l.input[l.pos+1:]); !IsIdentStart(r) {
+		return l.readIllegal()
+	}
+
+	pos := l.position()
+	startPos := l.pos
 
 	l.advance()
+	tok, err := l.readIdent()
+	if err != nil {
+		return tok, err
+	}
+	return Token{Type: DIRECTIVE, Value: l.input[startPos:l.pos], Pos: pos}, nil
+}
 
-	return Token{Type: STRING, Literal: string(l.input[startPos : l.pos-1])}, nil
+// readIllegal consumes the character the lexer is stuck on, returning it as an ILLEGAL token alongside the error.
+func (l *Lexer) readIllegal() (Token, error) {
+	pos := l.position()
+	r, size := l.peekRune()
+	literal := l.input[l.pos : l.pos+size]
+
+	for range size {
+		l.advance()
+	}
+
+	tok := Token{Type: ILLEGAL, Value: literal, Pos: pos}
+	if r == utf8.RuneError && size == 1 {
+		return tok, Errorf(pos, "illegal character: invalid utf8 byte %#x", literal[0])
+	}
+	return tok, Errorf(pos, "illegal character %q", r)
 }
 
 func (l *Lexer) readNumber() (Token, error) {
+	pos := l.position()
 	startPos := l.pos
 	tokType := INT
 	float := false
 
-	if l.ch == '-' {
+	if l.ch == '-' || l.ch == '+' {
 		l.advance()
+
+		if IsLetter(l.ch) {
+			return l.readSignedKeyword(pos, startPos)
+		}
 	}
 
-	for IsDigit(l.ch) || l.ch == '.' {
+	if l.ch == '0' {
+		if base := prefixBase(l.peek()); base != 0 {
+			return l.readPrefixedInt(pos, startPos, base)
+		}
+	}
+
+	for IsDigit(l.ch) || l.ch == '.' || l.ch == '_' {
 		if l.ch == '.' {
 			if float {
-				return Token{}, errors.New("multiple dots not allowed in number")
+				return Token{}, Errorf(pos, "multiple dots not allowed in number")
 			}
 			float = true
 			tokType = FLOAT
+		} else if l.ch == '_' && !l.validSeparator(10) {
+			return Token{}, Errorf(l.position(), "_ must separate digits in number")
 		}
 		l.advance()
 	}
 
-	literal := string(l.input[startPos:l.pos])
+	literal := l.input[startPos:l.pos]
 
-	if literal == "-" {
-		return Token{}, errors.New("malformed number, only negative entered")
+	if len(literal) == 1 && (literal[0] == '-' || literal[0] == '+') {
+		return Token{}, Errorf(pos, "malformed number, only sign entered")
 	}
 
 	if literal[len(literal)-1] == '.' {
-		return Token{}, errors.New("numbers not allowed to end in dot")
+		return Token{}, Errorf(pos, "numbers not allowed to end in dot")
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		err := l.readExponent()
+		if err != nil {
+			return Token{}, err
+		}
+		tokType = FLOAT
+	}
+
+	return Token{Type: tokType, Value: l.input[startPos:l.pos], Pos: pos}, nil
+}
+
+// readExponent reads the e part of a number in scientific notation, along with its optional sign.
+func (l *Lexer) readExponent() error {
+	pos := l.position()
+	l.advance() // go past e
+
+	if l.ch == '-' || l.ch == '+' {
+		l.advance()
+	}
+
+	if !IsDigit(l.ch) {
+		return Errorf(pos, "exponent must have digits")
+	}
+
+	for IsDigit(l.ch) || l.ch == '_' {
+		if l.ch == '_' && !l.validSeparator(10) {
+			return Errorf(l.position(), "_ must separate digits in number")
+		}
+		l.advance()
+	}
+
+	return nil
+}
+
+// readSignedKeyword reads the keyword after a sign, which is only allowed for inf and nan.
+func (l *Lexer) readSignedKeyword(pos Position, startPos int) (Token, error) {
+	for IsLetter(l.ch) {
+		l.advance()
+	}
+
+	keyword := l.input[startPos+1 : l.pos]
+	if keywordToken[string(keyword)] != FLOAT {
+		return Token{}, Errorf(pos, "only numbers, inf and nan can have a sign, got %s", keyword)
+	}
+
+	return Token{Type: FLOAT, Value: l.input[startPos:l.pos], Pos: pos}, nil
+}
+
+// prefixBase returns the base an integer prefix letter stands for, or 0 if ch isn't one.
+func prefixBase(ch byte) int {
+	switch ch | 0x20 {
+	case 'x':
+		return 16
+	case 'o':
+		return 8
+	case 'b':
+		return 2
+	default:
+		return 0
+	}
+}
+
+// readPrefixedInt reads a 0x, 0o or 0b integer, the lexer is on the 0 of the prefix. The literal keeps the prefix so
+// the decoder can pick the base.
+func (l *Lexer) readPrefixedInt(pos Position, startPos int, base int) (Token, error) {
+	l.advance() // go past 0
+	l.advance() // go past prefix letter
+
+	// a separator is allowed straight after the prefix, as in 0x_FF
+	if l.ch == '_' {
+		l.advance()
+	}
+
+	digits := 0
+	for isBaseDigit(l.ch, base) || l.ch == '_' {
+		if l.ch == '_' && !l.validSeparator(base) {
+			return Token{}, Errorf(l.position(), "_ must separate digits in number")
+		}
+		if l.ch != '_' {
+			digits += 1
+		}
+		l.advance()
+	}
+
+	if digits == 0 {
+		return Token{}, Errorf(pos, "number prefix must be followed by digits")
+	}
+
+	if IsDigit(l.ch) || IsLetter(l.ch) {
+		return Token{}, Errorf(l.position(), "invalid digit %q in base %d number", l.ch, base)
 	}
 
-	return Token{Type: tokType, Literal: literal}, nil
+	return Token{Type: INT, Value: l.input[startPos:l.pos], Pos: pos}, nil
 }
 
-func newSingleToken(tokType TokenType, ch byte) Token {
-	return Token{Type: tokType, Literal: string(ch)}
+// validSeparator reports whether the _ the lexer is on sits between two digits of base.
+func (l *Lexer) validSeparator(base int) bool {
+	return l.pos > 0 && isBaseDigit(l.input[l.pos-1], base) && isBaseDigit(l.peek(), base)
 }
@@ -1,7 +1,12 @@
 package lexer
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNextToken(t *testing.T) {
@@ -9,7 +14,9 @@ func TestNextToken(t *testing.T) {
 []
 ()
 {}
-=,
+=,:.
+@include
+${Server[0].host}
 1.23
 123
 "hello", "hello"
@@ -31,6 +38,10 @@ nil
 		newToken(RBRACE, "}"),
 		newToken(ASSIGN, "="),
 		newToken(COMMA, ","),
+		newToken(COLON, ":"),
+		newToken(DOT, "."),
+		newToken(DIRECTIVE, "@include"),
+		newToken(REFERENCE, "${Server[0].host}"),
 		newToken(FLOAT, "1.23"),
 		newToken(INT, "123"),
 		newToken(STRING, "hello"),
@@ -53,14 +64,475 @@ nil
 	for _, tt := range expectedTokenTypes {
 		token, err := l.NextToken()
 
-		if token != tt || err != nil {
+		if token.Type != tt.Type || token.Literal() != tt.Literal() || err != nil {
 			t.Errorf("NextToken=%v, %v, wanted match for %v", token, err, tt)
 		}
 	}
 }
 
+func TestTokenPositions(t *testing.T) {
+	input := "a = 1\n\tbb = \"x\""
+
+	expectedPositions := []Position{
+		{Filename: "test.gcfg", Offset: 0, Line: 1, Column: 1},
+		{Filename: "test.gcfg", Offset: 2, Line: 1, Column: 3},
+		{Filename: "test.gcfg", Offset: 4, Line: 1, Column: 5},
+		{Filename: "test.gcfg", Offset: 7, Line: 2, Column: 2},
+		{Filename: "test.gcfg", Offset: 10, Line: 2, Column: 5},
+		{Filename: "test.gcfg", Offset: 12, Line: 2, Column: 7},
+		{Filename: "test.gcfg", Offset: 15, Line: 2, Column: 10},
+	}
+
+	l := NewNamed("test.gcfg", []byte(input))
+
+	for _, pos := range expectedPositions {
+		token, err := l.NextToken()
+
+		if token.Pos != pos || err != nil {
+			t.Errorf("NextToken=%v at %v, %v, wanted position %v", token, token.Pos, err, pos)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	l := NewNamed("test.gcfg", []byte("a = 1\nb = 1.2.3"))
+
+	var err error
+	for err == nil {
+		var tok Token
+		tok, err = l.NextToken()
+		if tok.Type == EOF {
+			break
+		}
+	}
+
+	expected := "test.gcfg:2:5: multiple dots not allowed in number"
+	if err == nil || err.Error() != expected {
+		t.Errorf("NextToken error=%v, wanted %s", err, expected)
+	}
+}
+
+func TestIntLiterals(t *testing.T) {
+	input := "0xFF00 0o644 0b1010 10_000_000 -0x_1f 0XaB 1_000.5"
+
+	expected := []Token{
+		newToken(INT, "0xFF00"),
+		newToken(INT, "0o644"),
+		newToken(INT, "0b1010"),
+		newToken(INT, "10_000_000"),
+		newToken(INT, "-0x_1f"),
+		newToken(INT, "0XaB"),
+		newToken(FLOAT, "1_000.5"),
+		newToken(EOF, ""),
+	}
+
+	l := New([]byte(input))
+
+	for _, tt := range expected {
+		token, err := l.NextToken()
+
+		if token.Type != tt.Type || token.Literal() != tt.Literal() || err != nil {
+			t.Errorf("NextToken=%v, %v, wanted match for %v", token, err, tt)
+		}
+	}
+}
+
+func TestFloatLiterals(t *testing.T) {
+	input := "1e-6 6.02e23 1E5 +3 +2.5 -1.5e+3 inf -inf +nan nan"
+
+	expected := []Token{
+		newToken(FLOAT, "1e-6"),
+		newToken(FLOAT, "6.02e23"),
+		newToken(FLOAT, "1E5"),
+		newToken(INT, "+3"),
+		newToken(FLOAT, "+2.5"),
+		newToken(FLOAT, "-1.5e+3"),
+		newToken(FLOAT, "inf"),
+		newToken(FLOAT, "-inf"),
+		newToken(FLOAT, "+nan"),
+		newToken(FLOAT, "nan"),
+		newToken(EOF, ""),
+	}
+
+	l := New([]byte(input))
+
+	for _, tt := range expected {
+		token, err := l.NextToken()
+
+		if token.Type != tt.Type || token.Literal() != tt.Literal() || err != nil {
+			t.Errorf("NextToken=%v, %v, wanted match for %v", token, err, tt)
+		}
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	input := "key2 max-conns _private größe trueish nil2"
+
+	expected := []Token{
+		newToken(IDENT, "key2"),
+		newToken(IDENT, "max-conns"),
+		newToken(IDENT, "_private"),
+		newToken(IDENT, "größe"),
+		newToken(IDENT, "trueish"),
+		newToken(IDENT, "nil2"),
+		newToken(EOF, ""),
+	}
+
+	l := New([]byte(input))
+
+	for _, tt := range expected {
+		token, err := l.NextToken()
+
+		if token.Type != tt.Type || token.Literal() != tt.Literal() || err != nil {
+			t.Errorf("NextToken=%v, %v, wanted match for %v", token, err, tt)
+		}
+	}
+}
+
+func TestIllegalCharacters(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		literal  string
+		expected string
+	}{
+		{
+			name:     "At",
+			input:    "a = 1\n  @",
+			literal:  "@",
+			expected: "test.gcfg:2:3: illegal character '@'",
+		},
+		{
+			name:     "Symbol",
+			input:    "€",
+			literal:  "€",
+			expected: "test.gcfg:1:1: illegal character '€'",
+		},
+		{
+			name:     "UnterminatedReference",
+			input:    "${Server.host\n}",
+			literal:  "${Server.host",
+			expected: "test.gcfg:1:1: unterminated reference",
+		},
+		{
+			name:     "InvalidUTF8",
+			input:    "\xff",
+			literal:  "\xff",
+			expected: "test.gcfg:1:1: illegal character: invalid utf8 byte 0xff",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewNamed("test.gcfg", []byte(tt.input))
+
+			var tok Token
+			var err error
+			for err == nil && tok.Type != EOF {
+				tok, err = l.NextToken()
+			}
+
+			if tok.Type != ILLEGAL || tok.Literal() != tt.literal || err == nil || err.Error() != tt.expected {
+				t.Errorf("NextToken=%v, %v, wanted ILLEGAL(%s), %s", tok, err, tt.literal, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNulByte(t *testing.T) {
+	input := "a = \"x\x00y\"\nb = 1\x00 c"
+
+	tests := []struct {
+		name string
+		l    *Lexer
+	}{
+		{
+			name: "Bytes",
+			l:    NewNamed("test.gcfg", []byte(input)),
+		},
+		{
+			name: "Reader",
+			l:    NewReaderNamed("test.gcfg", iotest.OneByteReader(strings.NewReader(input))),
+		},
+	}
+
+	expected := []Token{
+		newToken(IDENT, "a"),
+		newToken(ASSIGN, "="),
+		newToken(STRING, "x\x00y"),
+		newToken(IDENT, "b"),
+		newToken(ASSIGN, "="),
+		newToken(INT, "1"),
+		newToken(ILLEGAL, "\x00"),
+	}
+	expectedErr := `test.gcfg:2:6: illegal character '\x00'`
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, want := range expected {
+				tok, err := tt.l.NextToken()
+
+				if want.Type == ILLEGAL {
+					if tok.Type != ILLEGAL || tok.Literal() != want.Literal() || err == nil || err.Error() != expectedErr {
+						t.Errorf("NextToken=%v, %v, wanted ILLEGAL(%s), %s", tok, err, want.Literal(), expectedErr)
+					}
+					break
+				}
+				if tok.Type != want.Type || tok.Literal() != want.Literal() || err != nil {
+					t.Fatalf("NextToken=%v, %v, wanted %v", tok, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `# hash
+a = 1 // slashes
+/* block
+comment */ b
+`
+
+	tests := []struct {
+		name     string
+		mode     Mode
+		expected []Token
+	}{
+		{
+			name: "Skipped",
+			expected: []Token{
+				newToken(IDENT, "a"),
+				newToken(ASSIGN, "="),
+				newToken(INT, "1"),
+				newToken(IDENT, "b"),
+				newToken(EOF, ""),
+			},
+		},
+		{
+			name: "Scanned",
+			mode: ScanComments,
+			expected: []Token{
+				newToken(COMMENT, "# hash"),
+				newToken(IDENT, "a"),
+				newToken(ASSIGN, "="),
+				newToken(INT, "1"),
+				newToken(COMMENT, "// slashes"),
+				newToken(COMMENT, "/* block\ncomment */"),
+				newToken(IDENT, "b"),
+				newToken(EOF, ""),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New([]byte(input))
+			l.SetMode(tt.mode)
+
+			for _, expected := range tt.expected {
+				token, err := l.NextToken()
+
+				if token.Type != expected.Type || token.Literal() != expected.Literal() || err != nil {
+					t.Errorf("NextToken=%v, %v, wanted match for %v", token, err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		raw      bool
+	}{
+		{
+			name:     "Plain",
+			input:    `"hello"`,
+			expected: "hello",
+		},
+		{
+			name:     "SimpleEscapes",
+			input:    `"a\"b\\c\nd\te\/f\r"`,
+			expected: "a\"b\\c\nd\te/f\r",
+		},
+		{
+			name:     "Unicode",
+			input:    `"\u00e9\U0001F600x"`,
+			expected: "é😀x",
+		},
+		{
+			name:     "Raw",
+			input:    "`C:\\path\\n \"q\"`",
+			expected: `C:\path\n "q"`,
+			raw:      true,
+		},
+		{
+			name:     "RawMultiline",
+			input:    "`a\n\tb`",
+			expected: "a\n\tb",
+			raw:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New([]byte(tt.input))
+			token, err := l.NextToken()
+
+			if token.Type != STRING || token.Literal() != tt.expected || token.Raw != tt.raw || err != nil {
+				t.Errorf("NextToken=%v, %v, wanted STRING(%s) with Raw=%v", token, err, tt.expected, tt.raw)
+			}
+		})
+	}
+}
+
+func TestMultilineStrings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "SingleLine",
+			input:    `"""a "quoted" word"""`,
+			expected: `a "quoted" word`,
+		},
+		{
+			name:     "Dedent",
+			input:    "\"\"\"\n\t\tSELECT *\n\t\t  FROM t\n\n\t\tWHERE x\n\t\"\"\"",
+			expected: "SELECT *\n  FROM t\n\nWHERE x\n",
+		},
+		{
+			name:     "TextOnFirstLine",
+			input:    "\"\"\"first\n    second\n    third\"\"\"",
+			expected: "first\nsecond\nthird",
+		},
+		{
+			name:     "Continuation",
+			input:    "\"\"\"\n    one \\\n      two\n    three\"\"\"",
+			expected: "one two\nthree",
+		},
+		{
+			name:     "Escapes",
+			input:    "\"\"\"\n  a\\tb\n  \\\"\"\"\n  \\\\\"\"\"",
+			expected: "a\tb\n\"\"\"\n\\",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New([]byte(tt.input))
+			token, err := l.NextToken()
+
+			if token.Type != STRING || token.Literal() != tt.expected || err != nil {
+				t.Errorf("NextToken=%q, %v, wanted %q", token.Literal(), err, tt.expected)
+			}
+
+			token, err = l.NextToken()
+			if token.Type != EOF || err != nil {
+				t.Errorf("NextToken=%v, %v, wanted EOF after string", token, err)
+			}
+		})
+	}
+}
+
+func TestMultilineStringErrorPosition(t *testing.T) {
+	l := NewNamed("test.gcfg", []byte("a = \"\"\"\n  ok\n  bad \\q\n\"\"\""))
+
+	var err error
+	for err == nil {
+		var tok Token
+		tok, err = l.NextToken()
+		if tok.Type == EOF {
+			break
+		}
+	}
+
+	expected := "test.gcfg:3:7: unknown escape sequence \\q"
+	if err == nil || err.Error() != expected {
+		t.Errorf("NextToken error=%v, wanted %s", err, expected)
+	}
+}
+
+func TestRecoverErrors(t *testing.T) {
+	input := `a = 1.2.3, $foo
+b = [0x, 2]
+f = "x\q y"
+g = 1.2.3
+h = @
+c = "ok" d = "bad\q" e = "unterminated`
+
+	expected := []Token{
+		newToken(IDENT, "a"),
+		newToken(ASSIGN, "="),
+		newToken(ILLEGAL, "1.2.3"),
+		newToken(COMMA, ","),
+		newToken(ILLEGAL, "$foo"),
+		newToken(IDENT, "b"),
+		newToken(ASSIGN, "="),
+		newToken(LBRACKET, "["),
+		newToken(ILLEGAL, "0x"),
+		newToken(COMMA, ","),
+		newToken(INT, "2"),
+		newToken(RBRACKET, "]"),
+		newToken(IDENT, "f"),
+		newToken(ASSIGN, "="),
+		newToken(ILLEGAL, `"x\q y"`),
+		newToken(IDENT, "g"),
+		newToken(ASSIGN, "="),
+		newToken(ILLEGAL, "1.2.3"),
+		newToken(IDENT, "h"),
+		newToken(ASSIGN, "="),
+		newToken(ILLEGAL, "@"),
+		newToken(IDENT, "c"),
+		newToken(ASSIGN, "="),
+		newToken(STRING, "ok"),
+		newToken(IDENT, "d"),
+		newToken(ASSIGN, "="),
+		newToken(ILLEGAL, `"bad\q"`),
+		newToken(IDENT, "e"),
+		newToken(ASSIGN, "="),
+		newToken(ILLEGAL, `"unterminated`),
+		newToken(EOF, ""),
+	}
+
+	expectedErrors := []string{
+		"test.gcfg:1:5: multiple dots not allowed in number",
+		"test.gcfg:1:12: illegal character '$'",
+		"test.gcfg:2:6: number prefix must be followed by digits",
+		"test.gcfg:3:7: unknown escape sequence \\q",
+		"test.gcfg:4:5: multiple dots not allowed in number",
+		"test.gcfg:5:5: illegal character '@'",
+		"test.gcfg:6:18: unknown escape sequence \\q",
+		"test.gcfg:6:26: malformed string",
+	}
+
+	l := NewNamed("test.gcfg", []byte(input))
+	l.SetMode(RecoverErrors)
+
+	for _, tt := range expected {
+		token, err := l.NextToken()
+
+		if token.Type != tt.Type || token.Literal() != tt.Literal() || err != nil {
+			t.Errorf("NextToken=%v, %v, wanted match for %v", token, err, tt)
+		}
+	}
+
+	errs := l.Errors()
+	if len(errs) != len(expectedErrors) {
+		t.Fatalf("Errors=%v, wanted %d errors", errs, len(expectedErrors))
+	}
+	for i, err := range errs {
+		if err.Error() != expectedErrors[i] {
+			t.Errorf("Errors[%d]=%v, wanted %s", i, err, expectedErrors[i])
+		}
+	}
+}
+
 func newToken(tokenType TokenType, lit string) Token {
-	return Token{Type: tokenType, Literal: lit}
+	return Token{Type: tokenType, Value: []byte(lit)}
 }
 
 func TestBadInput(t *testing.T) {
@@ -76,10 +548,82 @@ func TestBadInput(t *testing.T) {
 			name:  "EndInDot",
 			input: "123.",
 		},
+		{
+			name:  "OnlySign",
+			input: "+",
+		},
+		{
+			name:  "EmptyExponent",
+			input: "1e",
+		},
+		{
+			name:  "SignedExponentWithoutDigits",
+			input: "1e+",
+		},
+		{
+			name:  "SignedKeyword",
+			input: "-true",
+		},
+		{
+			name:  "LeadingSeparator",
+			input: "-_1",
+		},
+		{
+			name:  "TrailingSeparator",
+			input: "1_",
+		},
+		{
+			name:  "DoubleSeparator",
+			input: "1__0",
+		},
+		{
+			name:  "SeparatorBeforeDot",
+			input: "1_.5",
+		},
+		{
+			name:  "EmptyPrefix",
+			input: "0x",
+		},
+		{
+			name:  "BadBinaryDigit",
+			input: "0b102",
+		},
+		{
+			name:  "BadHexDigit",
+			input: "0xFG",
+		},
 		{
 			name:  "MalformedString",
 			input: `"hey`,
 		},
+		{
+			name:  "UnknownEscape",
+			input: `"\q"`,
+		},
+		{
+			name:  "ShortUnicodeEscape",
+			input: `"\u12"`,
+		},
+		{
+			name:  "InvalidCodePoint",
+			input: `"\UFFFFFFFF"`,
+		},
+		{
+			name:  "MalformedRawString",
+			input: "`hey",
+		},
+		{
+			name:  "MalformedMultilineString",
+			input: `"""hey""`,
+		},
+		{
+			name:  "UnterminatedBlockComment",
+			input: "/* hey",
+		},
+		{
+			name:  "LoneSlash",
+			input: "/ hey",
+		},
 	}
 
 	for _, tt := range tests {
@@ -93,3 +637,132 @@ func TestBadInput(t *testing.T) {
 		})
 	}
 }
+
+func TestReaderMatchesBytes(t *testing.T) {
+	var b strings.Builder
+	for i := range 2000 {
+		b.WriteString("key_")
+		b.WriteString(strings.Repeat("x", i%7))
+		b.WriteString(" = [0x1F, -2.5e3, \"esc\\taped\", `raw`] # comment\n")
+	}
+	// tokens much longer than the read chunk have to survive the buffer being refilled under them
+	b.WriteString("long = \"" + strings.Repeat("y", 3*readChunk) + "\"\n")
+	b.WriteString("block = \"\"\"\n\t" + strings.Repeat("z", readChunk) + "\n\t\"\"\"\n")
+	input := []byte(b.String())
+
+	tests := []struct {
+		name string
+		l    *Lexer
+	}{
+		{
+			name: "Buffered",
+			l:    NewReader(bytes.NewReader(input)),
+		},
+		{
+			name: "OneByte",
+			l:    NewReader(iotest.OneByteReader(bytes.NewReader(input))),
+		},
+		{
+			name: "HalfReads",
+			l:    NewReader(iotest.HalfReader(bytes.NewReader(input))),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := New(input)
+
+			for {
+				want, wantErr := expected.NextToken()
+				got, err := tt.l.NextToken()
+
+				if got.Type != want.Type || got.Literal() != want.Literal() || got.Pos != want.Pos || err != wantErr {
+					t.Fatalf("NextToken=%v at %v, %v, wanted %v at %v, %v", got, got.Pos, err, want, want.Pos, wantErr)
+				}
+				if want.Type == EOF {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("a = \"unfinished"), iotest.ErrReader(readErr))
+	l := NewReaderNamed("test.gcfg", r)
+
+	var err error
+	for err == nil {
+		var tok Token
+		tok, err = l.NextToken()
+		if tok.Type == EOF {
+			break
+		}
+	}
+
+	if !errors.Is(err, readErr) {
+		t.Errorf("NextToken error=%v, wanted %v", err, readErr)
+	}
+}
+
+var benchInput = []byte(`
+# service config
+name = "templating"
+port = 8080
+ratio = 0.75
+tags = ["a", "b", "c"]
+limits = (0x10, 1_000)
+
+Server {
+	host = "localhost"
+	escaped = "line\nbreak"
+	enabled = true
+}
+
+[Route] {
+	path = "/a"
+	weight = 1
+}
+
+[Route] {
+	path = "/b"
+	weight = 2
+}
+`)
+
+func BenchmarkNextToken(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchInput)))
+
+	for b.Loop() {
+		l := New(benchInput)
+		for {
+			tok, err := l.NextToken()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if tok.Type == EOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkNextTokenReader(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchInput)))
+
+	for b.Loop() {
+		l := NewReader(bytes.NewReader(benchInput))
+		for {
+			tok, err := l.NextToken()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if tok.Type == EOF {
+				break
+			}
+		}
+	}
+}
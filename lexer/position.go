@@ -0,0 +1,103 @@
+package lexer
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Position is a location in the input. Line and Column are 1-based, Column counts bytes and Offset is the 0-based
+// byte offset from the start of the input.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position points at a real location, the zero Position doesn't.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String formats the position as file:line:col, leaving out the parts that aren't known.
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+	}
+	return s
+}
+
+// Error is an error tied to a position in the input.
+type Error struct {
+	Pos Position
+	Err error
+}
+
+// Errorf creates an *Error at pos, the format is handled by fmt.Errorf so %w can be used to wrap errors.
+func Errorf(pos Position, format string, args ...any) error {
+	return &Error{Pos: pos, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	pos := e.Pos.String()
+	if pos == "" {
+		return e.Err.Error()
+	}
+	return pos + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is a list of errors found in one pass over the input, in the order they were found.
+type ErrorList []*Error
+
+func (el *ErrorList) Add(err *Error) {
+	*el = append(*el, err)
+}
+
+// Sort orders the list by position, keeping the order errors were added in for ones at the same position.
+func (el ErrorList) Sort() {
+	slices.SortStableFunc(el, func(a, b *Error) int {
+		return a.Pos.Offset - b.Pos.Offset
+	})
+}
+
+// Err returns the list as an error, or nil if it's empty.
+func (el ErrorList) Err() error {
+	if len(el) == 0 {
+		return nil
+	}
+	return el
+}
+
+func (el ErrorList) Error() string {
+	switch len(el) {
+	case 0:
+		return "no errors"
+	case 1:
+		return el[0].Error()
+	}
+
+	msgs := make([]string, len(el))
+	for i, err := range el {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap lets errors.Is and errors.As look at every error in the list.
+func (el ErrorList) Unwrap() []error {
+	errs := make([]error, len(el))
+	for i, err := range el {
+		errs[i] = err
+	}
+	return errs
+}
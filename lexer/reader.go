@@ -0,0 +1,77 @@
+package lexer
+
+import (
+	"errors"
+	"io"
+)
+
+// readChunk is how much NewReader lexers read at a time, and how much already scanned input they let build up before
+// dropping it.
+const readChunk = 4096
+
+// NewReader creates a lexer that reads its input from r as it goes. Only the token being scanned and a small buffer
+// are kept in memory, so it can handle input of any size.
+func NewReader(r io.Reader) *Lexer {
+	return NewReaderNamed("", r)
+}
+
+// NewReaderNamed is like NewReader, with token positions carrying filename like NewNamed.
+func NewReaderNamed(filename string, r io.Reader) *Lexer {
+	l := &Lexer{
+		input:    make([]byte, 0, readChunk),
+		reader:   r,
+		filename: filename,
+		line:     1,
+	}
+	l.advance()
+	return l
+}
+
+// fill appends the next chunk of the reader to the input, growing the buffer once a token has filled it. It reports
+// whether any input was added.
+func (l *Lexer) fill() bool {
+	if l.reader == nil || l.readErr != nil {
+		return false
+	}
+
+	if len(l.input) == cap(l.input) {
+		grown := make([]byte, len(l.input), 2*cap(l.input))
+		copy(grown, l.input)
+		l.input = grown
+	}
+
+	// io.Reader allows empty reads without an error, so keep going until there's something or the reader is done
+	for range 100 {
+		n, err := l.reader.Read(l.input[len(l.input):cap(l.input)])
+		l.input = l.input[:len(l.input)+n]
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				l.reader = nil
+			} else {
+				l.readErr = err
+			}
+			return n > 0
+		}
+		if n > 0 {
+			return true
+		}
+	}
+
+	l.readErr = io.ErrNoProgress
+	return false
+}
+
+// discard drops the input before the current character once enough of it has built up. It must only be called
+// between tokens, as the scanning code keeps indexes into the input while reading a token.
+func (l *Lexer) discard() {
+	if l.reader == nil || l.pos < readChunk {
+		return
+	}
+
+	n := copy(l.input, l.input[l.pos:])
+	l.input = l.input[:n]
+	l.offset += l.pos
+	l.readPos -= l.pos
+	l.pos = 0
+}
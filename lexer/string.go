@@ -0,0 +1,298 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+func (l *Lexer) readString() (Token, error) {
+	pos := l.position()
+
+	l.advance() // go past "
+
+	startPos := l.pos
+	// only used once an escape shows up, plain strings are sliced straight out of the input
+	var buf []byte
+	escaped := false
+
+	for l.ch != '"' {
+		if l.eof {
+			return Token{}, Errorf(pos, "malformed string")
+		}
+
+		if l.ch == '\\' {
+			if !escaped {
+				buf = l.scratch[:0]
+				escaped = true
+			}
+			buf = append(buf, l.input[startPos:l.pos]...)
+
+			l.peekAt(maxEscapeLen - 1) // make sure the whole escape has been read
+			var n int
+			var err error
+			buf, n, err = appendEscape(buf, l.input[l.pos:])
+			if err != nil {
+				return Token{}, Errorf(l.position(), "%w", err)
+			}
+			for range n {
+				l.advance()
+			}
+			startPos = l.pos
+			continue
+		}
+
+		l.advance()
+	}
+
+	literal := l.input[startPos:l.pos]
+	if escaped {
+		buf = append(buf, literal...)
+		l.scratch = buf
+		literal = buf
+	}
+
+	l.advance() // go past "
+
+	return Token{Type: STRING, Value: literal, Pos: pos}, nil
+}
+
+// readMultilineString reads a """ delimited string. A newline straight after the opening quotes is dropped, as is the
+// indentation shared by every non-blank line after the first, so the string can be indented along with the config
+// around it. Escapes work like in normal strings, and a backslash at the end of a line joins it to the next one,
+// dropping the newline and the next line's leading whitespace.
+func (l *Lexer) readMultilineString() (Token, error) {
+	pos := l.position()
+
+	for range 3 {
+		l.advance() // go past """
+	}
+
+	startPos := l.pos
+	for l.ch != '"' || l.peek() != '"' || l.peekAt(2) != '"' {
+		if l.eof {
+			return Token{}, Errorf(pos, "malformed multiline string")
+		}
+		if l.ch == '\\' {
+			l.advance() // an escaped quote can't close the string
+			if l.eof {
+				return Token{}, Errorf(pos, "malformed multiline string")
+			}
+		}
+		l.advance()
+	}
+	endPos := l.pos
+
+	for range 3 {
+		l.advance() // go past """
+	}
+
+	l.lines = splitLines(l.lines[:0], l.input, startPos, endPos)
+	lines := l.lines
+
+	firstLine := lines[0]
+	if isBlank(l.input[firstLine.start:firstLine.end]) {
+		lines = lines[1:]
+	}
+
+	indent := commonIndent(l.input, lines)
+
+	buf := l.scratch[:0]
+	joining := false
+	for i, line := range lines {
+		start := line.start
+		// the line right after the opening quotes isn't indented with the rest
+		if line.line != 0 {
+			start = min(start+len(indent), line.end)
+			if isBlank(l.input[start:line.end]) {
+				start = line.end
+			}
+		}
+
+		if joining {
+			for start < line.end && (l.input[start] == ' ' || l.input[start] == '\t') {
+				start += 1
+			}
+		}
+		joining = false
+
+		for j := start; j < line.end; {
+			if l.input[j] != '\\' {
+				buf = append(buf, l.input[j])
+				j += 1
+				continue
+			}
+
+			if i < len(lines)-1 && isBlank(l.input[j+1:line.end]) {
+				joining = true
+				break
+			}
+
+			var n int
+			var err error
+			buf, n, err = appendEscape(buf, l.input[j:line.end])
+			if err != nil {
+				errPos := Position{
+					Filename: l.filename,
+					Offset:   l.offset + j,
+					Line:     pos.Line + line.line,
+					Column:   j - line.start + 1,
+				}
+				if line.line == 0 {
+					errPos.Column = pos.Column + errPos.Offset - pos.Offset
+				}
+				return Token{}, Errorf(errPos, "%w", err)
+			}
+			j += n
+		}
+
+		if i < len(lines)-1 && !joining {
+			buf = append(buf, '\n')
+		}
+	}
+
+	l.scratch = buf
+
+	return Token{Type: STRING, Value: buf, Pos: pos}, nil
+}
+
+// readRawString reads a backtick delimited string, its contents are taken verbatim with no escapes.
+func (l *Lexer) readRawString() (Token, error) {
+	pos := l.position()
+
+	l.advance() // go past `
+	startPos := l.pos
+
+	for l.ch != '`' {
+		if l.eof {
+			return Token{}, Errorf(pos, "malformed raw string")
+		}
+		l.advance()
+	}
+
+	literal := l.input[startPos:l.pos]
+	l.advance() // go past `
+
+	return Token{Type: STRING, Value: literal, Pos: pos, Raw: true}, nil
+}
+
+// maxEscapeLen is the length of the longest escape sequence, \UXXXXXXXX.
+const maxEscapeLen = 10
+
+var simpleEscapes = map[byte]byte{
+	'"':  '"',
+	'\\': '\\',
+	'/':  '/',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+}
+
+// appendEscape decodes the escape sequence at the start of src, which begins with the backslash, and appends it to
+// buf. It returns how many bytes of src the sequence took up.
+func appendEscape(buf []byte, src []byte) ([]byte, int, error) {
+	if len(src) < 2 {
+		return nil, 0, errors.New("malformed string")
+	}
+
+	if unescaped, exists := simpleEscapes[src[1]]; exists {
+		return append(buf, unescaped), 2, nil
+	}
+
+	var digits int
+	switch src[1] {
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	default:
+		return nil, 0, fmt.Errorf("unknown escape sequence \\%c", src[1])
+	}
+
+	if len(src) < 2+digits {
+		return nil, 0, fmt.Errorf("escape sequence needs %d hex digits", digits)
+	}
+
+	var r rune
+	for _, ch := range src[2 : 2+digits] {
+		digit, ok := hexValue(ch)
+		if !ok {
+			return nil, 0, fmt.Errorf("escape sequence needs %d hex digits", digits)
+		}
+		r = r*16 + rune(digit)
+	}
+
+	if !utf8.ValidRune(r) {
+		return nil, 0, errors.New("escape sequence is not a valid unicode code point")
+	}
+
+	return utf8.AppendRune(buf, r), 2 + digits, nil
+}
+
+type lineSpan struct {
+	start int
+	end   int
+	line  int
+}
+
+// splitLines splits input[start:end] on newlines, appending them to lines. Line numbers are relative to the first
+// line, which is 0.
+func splitLines(lines []lineSpan, input []byte, start, end int) []lineSpan {
+	lineStart := start
+	for i := start; i < end; i++ {
+		if input[i] == '\n' {
+			lines = append(lines, lineSpan{start: lineStart, end: i, line: len(lines)})
+			lineStart = i + 1
+		}
+	}
+
+	return append(lines, lineSpan{start: lineStart, end: end, line: len(lines)})
+}
+
+// commonIndent returns the longest run of leading spaces and tabs shared by every non-blank line except the first,
+// which sits on the same line as the opening quotes.
+func commonIndent(input []byte, lines []lineSpan) []byte {
+	var indent []byte
+	found := false
+
+	for _, line := range lines {
+		if line.line == 0 {
+			continue
+		}
+
+		text := input[line.start:line.end]
+		if isBlank(text) {
+			continue
+		}
+
+		n := 0
+		for n < len(text) && (text[n] == ' ' || text[n] == '\t') {
+			n += 1
+		}
+
+		if !found {
+			indent = text[:n]
+			found = true
+			continue
+		}
+
+		common := 0
+		for common < len(indent) && common < n && indent[common] == text[common] {
+			common += 1
+		}
+		indent = indent[:common]
+	}
+
+	return indent
+}
+
+func isBlank(text []byte) bool {
+	for _, ch := range text {
+		if ch != ' ' && ch != '\t' && ch != '\r' {
+			return false
+		}
+	}
+	return true
+}
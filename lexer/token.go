@@ -15,6 +15,8 @@ const (
 
 	ASSIGN
 	COMMA
+	COLON
+	DOT
 
 	IDENT
 	INT
@@ -23,15 +25,34 @@ const (
 	TRUE
 	FALSE
 	NULL
+	// REFERENCE is ${path}, a value taken from elsewhere in the config.
+	REFERENCE
+
+	// DIRECTIVE is @ followed by a name, like @include.
+	DIRECTIVE
+	COMMENT
+	ILLEGAL
 
 	EOF
 )
 
 type Token struct {
-	Type    TokenType
-	Literal string
+	Type TokenType
+	// Value is the token's text, for strings that's their contents with escapes decoded. It points into the lexer's
+	// buffers instead of being copied out, so it's only valid until the next call to NextToken, use Literal to keep it.
+	Value []byte
+	Pos   Position
+	// End is the position just past the token.
+	End Position
+	// Raw is set for backtick strings, whose contents are taken verbatim.
+	Raw bool
+}
+
+// Literal returns a copy of the token's text as a string.
+func (t Token) Literal() string {
+	return string(t.Value)
 }
 
 func (t Token) String() string {
-	return t.Type.String() + "(" + t.Literal + ")"
+	return t.Type.String() + "(" + string(t.Value) + ")"
 }
@@ -16,19 +16,25 @@ func _() {
 	_ = x[RBRACE-5]
 	_ = x[ASSIGN-6]
 	_ = x[COMMA-7]
-	_ = x[IDENT-8]
-	_ = x[INT-9]
-	_ = x[FLOAT-10]
-	_ = x[STRING-11]
-	_ = x[TRUE-12]
-	_ = x[FALSE-13]
-	_ = x[NULL-14]
-	_ = x[EOF-15]
+	_ = x[COLON-8]
+	_ = x[DOT-9]
+	_ = x[IDENT-10]
+	_ = x[INT-11]
+	_ = x[FLOAT-12]
+	_ = x[STRING-13]
+	_ = x[TRUE-14]
+	_ = x[FALSE-15]
+	_ = x[NULL-16]
+	_ = x[REFERENCE-17]
+	_ = x[DIRECTIVE-18]
+	_ = x[COMMENT-19]
+	_ = x[ILLEGAL-20]
+	_ = x[EOF-21]
 }
 
-const _TokenType_name = "LBRACKETRBRACKETLPARENRPARENLBRACERBRACEASSIGNCOMMAIDENTINTFLOATSTRINGTRUEFALSENULLEOF"
+const _TokenType_name = "LBRACKETRBRACKETLPARENRPARENLBRACERBRACEASSIGNCOMMACOLONDOTIDENTINTFLOATSTRINGTRUEFALSENULLREFERENCEDIRECTIVECOMMENTILLEGALEOF"
 
-var _TokenType_index = [...]uint8{0, 8, 16, 22, 28, 34, 40, 46, 51, 56, 59, 64, 70, 74, 79, 83, 86}
+var _TokenType_index = [...]uint8{0, 8, 16, 22, 28, 34, 40, 46, 51, 56, 59, 64, 67, 72, 78, 82, 87, 91, 100, 109, 116, 123, 126}
 
 func (i TokenType) String() string {
 	if i >= TokenType(len(_TokenType_index)-1) {
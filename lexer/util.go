@@ -1,9 +1,79 @@
 package lexer
 
+import "unicode"
+
+const (
+	classSpace uint8 = 1 << iota
+	classDigit
+	classHex
+	// classLetter is ASCII letters and _, the characters that can start an identifier without decoding utf8.
+	classLetter
+	// classIdent is the ASCII characters allowed after the first character of an identifier.
+	classIdent
+	classPunct
+)
+
+// charClass classifies every byte so the hot scanning loops need a single lookup per character.
+var charClass = func() [256]uint8 {
+	var table [256]uint8
+
+	for _, ch := range []byte(" \t\n\r") {
+		table[ch] |= classSpace
+	}
+	for ch := '0'; ch <= '9'; ch++ {
+		table[ch] |= classDigit | classHex | classIdent
+	}
+	for ch := 'a'; ch <= 'z'; ch++ {
+		table[ch] |= classLetter | classIdent
+		table[ch-'a'+'A'] |= classLetter | classIdent
+	}
+	for ch := 'a'; ch <= 'f'; ch++ {
+		table[ch] |= classHex
+		table[ch-'a'+'A'] |= classHex
+	}
+	table['_'] |= classLetter | classIdent
+	table['-'] |= classIdent
+	for _, ch := range []byte("[](){}=,:") {
+		table[ch] |= classPunct
+	}
+
+	return table
+}()
+
 func IsDigit(ch byte) bool {
-	return ch >= '0' && ch <= '9'
+	return charClass[ch]&classDigit != 0
 }
 
 func IsLetter(ch byte) bool {
-	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_'
+	return charClass[ch]&classLetter != 0
+}
+
+// IsIdentStart reports whether r can start an identifier, which is any unicode letter or _.
+func IsIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// IsIdentPart reports whether r can appear in an identifier after the first character, which also allows digits and -.
+func IsIdentPart(r rune) bool {
+	return IsIdentStart(r) || unicode.IsDigit(r) || r == '-'
+}
+
+func hexValue(ch byte) (byte, bool) {
+	switch {
+	case IsDigit(ch):
+		return ch - '0', true
+	case ch >= 'a' && ch <= 'f':
+		return ch - 'a' + 10, true
+	case ch >= 'A' && ch <= 'F':
+		return ch - 'A' + 10, true
+	default:
+		return 0, false
+	}
+}
+
+func isBaseDigit(ch byte, base int) bool {
+	if base == 16 {
+		return charClass[ch]&classHex != 0
+	}
+	return IsDigit(ch) && int(ch-'0') < base
 }
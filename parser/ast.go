@@ -0,0 +1,206 @@
+package parser
+
+import "github.com/grian32/gcfg/lexer"
+
+// Node is any node of the syntax tree. Pos is where the node starts and End is the position just past it.
+type Node interface {
+	Pos() lexer.Position
+	End() lexer.Position
+}
+
+// Decl is a top level statement, or a statement inside a section.
+type Decl interface {
+	Node
+	declNode()
+}
+
+// Value is the right hand side of an assignment, or an element of an array or pair.
+type Value interface {
+	Node
+	valueNode()
+}
+
+// File is a whole config, its declarations are kept in the order they were written.
+type File struct {
+	Decls []Decl
+	EOF   lexer.Position
+}
+
+type Ident struct {
+	NamePos lexer.Position
+	NameEnd lexer.Position
+	Name    string
+}
+
+// Assignment is key = value.
+type Assignment struct {
+	Key    *Ident
+	Assign lexer.Position
+	Value  Value
+}
+
+// Section is a named block, Name { ... }, or Name : Base { ... } when it inherits from the section Base. Parse fills in
+// Body with whatever it inherits that the section doesn't declare itself, ahead of its own declarations. A section only
+// written as dotted keys, Name.key = value, has no braces so Lbrace and Rbrace are left zero.
+type Section struct {
+	Name   *Ident
+	Colon  lexer.Position
+	Base   *Ident
+	Lbrace lexer.Position
+	Body   []Decl
+	Rbrace lexer.Position
+}
+
+// SectionArray is a single [Name] { ... } block, every block with the same name adds an element to the array.
+type SectionArray struct {
+	Lbrack lexer.Position
+	Section
+}
+
+type IntLit struct {
+	ValuePos lexer.Position
+	ValueEnd lexer.Position
+	// Literal is the integer as written, with any sign, base prefix and _ separators, as the range it has to fit in
+	// depends on what it's decoded into.
+	Literal string
+}
+
+type FloatLit struct {
+	ValuePos lexer.Position
+	ValueEnd lexer.Position
+	Literal  string
+	Value    float64
+}
+
+type StringLit struct {
+	ValuePos lexer.Position
+	ValueEnd lexer.Position
+	// Value is the string's contents, with escapes decoded and multiline strings dedented. Parse replaces any ${path}
+	// in it with the value path refers to, unless it's Raw.
+	Value string
+	// Raw is set for backtick strings, which are taken verbatim.
+	Raw bool
+}
+
+type BoolLit struct {
+	ValuePos lexer.Position
+	ValueEnd lexer.Position
+	Value    bool
+}
+
+type NilLit struct {
+	ValuePos lexer.Position
+	ValueEnd lexer.Position
+}
+
+// RefLit is a reference to another value, ${path}. Parse replaces it with the value it refers to.
+type RefLit struct {
+	ValuePos lexer.Position
+	ValueEnd lexer.Position
+	// Path is where the value is. Paths join keys with dots and index arrays and section arrays with brackets, so the
+	// host key of the second element of the section array Server is Server[1].host, and pair halves are addressed as
+	// key.First and key.Second.
+	Path string
+}
+
+type ArrayLit struct {
+	Lbrack lexer.Position
+	Elems  []Value
+	Rbrack lexer.Position
+}
+
+type PairLit struct {
+	Lparen lexer.Position
+	First  Value
+	Second Value
+	Rparen lexer.Position
+}
+
+// SectionLit is an inline section, { key = value, ... }. Body only ever holds assignments.
+type SectionLit struct {
+	Lbrace lexer.Position
+	Body   []Decl
+	Rbrace lexer.Position
+}
+
+func (i *Ident) Pos() lexer.Position        { return i.NamePos }
+func (a *Assignment) Pos() lexer.Position   { return a.Key.Pos() }
+func (s *Section) Pos() lexer.Position      { return s.Name.Pos() }
+func (s *SectionArray) Pos() lexer.Position { return s.Lbrack }
+func (x *IntLit) Pos() lexer.Position       { return x.ValuePos }
+func (x *FloatLit) Pos() lexer.Position     { return x.ValuePos }
+func (x *StringLit) Pos() lexer.Position    { return x.ValuePos }
+func (x *BoolLit) Pos() lexer.Position      { return x.ValuePos }
+func (x *NilLit) Pos() lexer.Position       { return x.ValuePos }
+func (x *RefLit) Pos() lexer.Position       { return x.ValuePos }
+func (x *ArrayLit) Pos() lexer.Position     { return x.Lbrack }
+func (x *PairLit) Pos() lexer.Position      { return x.Lparen }
+func (x *SectionLit) Pos() lexer.Position   { return x.Lbrace }
+
+func (i *Ident) End() lexer.Position      { return i.NameEnd }
+func (a *Assignment) End() lexer.Position { return a.Value.End() }
+func (x *IntLit) End() lexer.Position     { return x.ValueEnd }
+func (x *FloatLit) End() lexer.Position   { return x.ValueEnd }
+func (x *StringLit) End() lexer.Position  { return x.ValueEnd }
+func (x *BoolLit) End() lexer.Position    { return x.ValueEnd }
+func (x *NilLit) End() lexer.Position     { return x.ValueEnd }
+func (x *RefLit) End() lexer.Position     { return x.ValueEnd }
+func (x *ArrayLit) End() lexer.Position   { return endAfter(x.Rbrack) }
+func (x *PairLit) End() lexer.Position    { return endAfter(x.Rparen) }
+func (x *SectionLit) End() lexer.Position { return endAfter(x.Rbrace) }
+
+// End of a section written only as dotted keys is the end of the last one.
+func (s *Section) End() lexer.Position {
+	if !s.Rbrace.IsValid() {
+		return s.Body[len(s.Body)-1].End()
+	}
+	return endAfter(s.Rbrace)
+}
+
+func (*Assignment) declNode()   {}
+func (*Section) declNode()      {}
+func (*SectionArray) declNode() {}
+
+func (*IntLit) valueNode()     {}
+func (*FloatLit) valueNode()   {}
+func (*StringLit) valueNode()  {}
+func (*BoolLit) valueNode()    {}
+func (*NilLit) valueNode()     {}
+func (*RefLit) valueNode()     {}
+func (*ArrayLit) valueNode()   {}
+func (*PairLit) valueNode()    {}
+func (*SectionLit) valueNode() {}
+
+// DeclName returns the key or section name a declaration declares.
+func DeclName(decl Decl) string {
+	switch decl := decl.(type) {
+	case *Assignment:
+		return decl.Key.Name
+	case *Section:
+		return decl.Name.Name
+	case *SectionArray:
+		return decl.Name.Name
+	default:
+		return ""
+	}
+}
+
+func declKind(decl Decl) string {
+	switch decl.(type) {
+	case *Assignment:
+		return "key"
+	case *Section:
+		return "section"
+	case *SectionArray:
+		return "section array"
+	default:
+		return "declaration"
+	}
+}
+
+// endAfter returns the position just past the single character closing token at pos.
+func endAfter(pos lexer.Position) lexer.Position {
+	pos.Offset += 1
+	pos.Column += 1
+	return pos
+}
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/grian32/gcfg/lexer"
+)
+
+// SetFS sets the file system @include reads files from. Included paths are relative to the directory of the file
+// including them, starting from the lexer's filename for the top level file. Without a file system @include is an
+// error.
+func (p *Parser) SetFS(fsys fs.FS) {
+	p.fsys = fsys
+}
+
+// parseDirective parses a directive, the parser is on it and finishes on its last token. The only directive is
+// @include "pattern", which parses every file matching the glob pattern and adds their declarations to decls as if
+// they were written in place of the directive.
+func (p *Parser) parseDirective(decls *[]Decl, declared scope) error {
+	if p.curToken.Literal != "@include" {
+		return lexer.Errorf(p.curToken.Pos, "unknown directive %s", p.curToken.Literal)
+	}
+	at := p.curToken.Pos
+
+	if p.peekToken.Type != lexer.STRING {
+		return unexpected(p.peekToken, "after @include", "file name")
+	}
+	err := p.NextToken() // advance to the pattern
+	if err != nil {
+		return err
+	}
+	pattern := p.curToken.Literal
+
+	if p.fsys == nil {
+		return lexer.Errorf(at, "@include %q: no file system to include from", pattern)
+	}
+
+	// a pattern without any glob characters has to name a file, reading it will say if it doesn't exist
+	name := path.Join(path.Dir(p.l.Filename()), pattern)
+	names := []string{name}
+	if strings.ContainsAny(pattern, `*?[\`) {
+		names, err = fs.Glob(p.fsys, name)
+		if err != nil {
+			return lexer.Errorf(at, "@include %q: %w", pattern, err)
+		}
+	}
+
+	for _, name := range names {
+		p.include(at, pattern, name, decls, declared)
+	}
+	return nil
+}
+
+// include parses the file name, included by the directive at, adding its declarations to decls and declared. Errors
+// in the file are recorded at the directive, so each one reads as a trace down from the top level file.
+func (p *Parser) include(at lexer.Position, pattern string, name string, decls *[]Decl, declared scope) {
+	including := p.including
+	if len(including) == 0 && p.l.Filename() != "" {
+		including = []string{path.Clean(p.l.Filename())}
+	}
+
+	if i := slices.Index(including, name); i >= 0 {
+		cycle := append(slices.Clone(including[i:]), name)
+		p.error(lexer.Errorf(at, "@include %q: include cycle %s", pattern, strings.Join(cycle, " -> ")))
+		return
+	}
+
+	input, err := fs.ReadFile(p.fsys, name)
+	if err != nil {
+		p.error(lexer.Errorf(at, "@include %q: %w", pattern, err))
+		return
+	}
+
+	sub := New(lexer.NewNamed(name, input))
+	sub.mode, sub.maxDepth, sub.implicit, sub.shapes = p.mode, p.maxDepth, p.implicit, p.shapes
+	sub.fsys, sub.including = p.fsys, append(slices.Clip(including), name)
+
+	err = sub.parseDecls(decls, declared)
+	if err != nil {
+		p.error(lexer.Errorf(at, "@include %q: %w", pattern, err))
+		return
+	}
+	for _, err := range sub.errorList() {
+		p.error(lexer.Errorf(at, "@include %q: %w", pattern, err))
+	}
+}
@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/grian32/gcfg/lexer"
+)
+
+// resolveBases fills in what every section in decls, and in the sections nested in them, inherits from its base.
+func (p *Parser) resolveBases(decls []Decl) {
+	r := &resolver{p: p, resolved: make(map[*Section]bool)}
+	r.resolveAll(decls, nil)
+}
+
+type resolver struct {
+	p *Parser
+	// chain holds the sections whose bases are being resolved, outermost first, to catch a section inheriting from
+	// itself.
+	chain    []*Section
+	resolved map[*Section]bool
+}
+
+// resolveAll resolves the sections in decls. A base is looked up among the declarations next to the section first,
+// then in each enclosing body out to the top level, which are the scopes in outer.
+func (r *resolver) resolveAll(decls []Decl, outer []scope) {
+	sc := make(scope, len(decls))
+	for _, decl := range decls {
+		sc[DeclName(decl)] = decl
+	}
+	scopes := append(slices.Clip(outer), sc)
+
+	for _, decl := range decls {
+		section := sectionOf(decl)
+		if section == nil {
+			continue
+		}
+		r.resolve(section, scopes)
+		r.resolveAll(section.Body, scopes)
+	}
+}
+
+// resolve puts what section inherits from its base in front of its own body, after resolving the base's inheritance.
+func (r *resolver) resolve(section *Section, scopes []scope) {
+	if r.resolved[section] || section.Base == nil {
+		return
+	}
+	r.resolved[section] = true
+
+	base, baseScopes, err := lookupBase(section, scopes)
+	if err != nil {
+		r.p.error(err)
+		return
+	}
+
+	r.chain = append(r.chain, section)
+	defer func() { r.chain = r.chain[:len(r.chain)-1] }()
+
+	if i := slices.Index(r.chain, base); i >= 0 {
+		names := make([]string, 0, len(r.chain)-i+1)
+		for _, s := range r.chain[i:] {
+			names = append(names, s.Name.Name)
+		}
+		names = append(names, base.Name.Name)
+
+		r.p.error(lexer.Errorf(section.Base.Pos(), "section %s: inheritance cycle %s", section.Name.Name, strings.Join(names, " : ")))
+		return
+	}
+
+	r.resolve(base, baseScopes)
+	section.Body = inherit(base.Body, section.Body)
+}
+
+// lookupBase finds the section named as section's base in the innermost scope that declares it, returning it along
+// with the scopes visible from there.
+func lookupBase(section *Section, scopes []scope) (*Section, []scope, error) {
+	name := section.Base.Name
+
+	for i := len(scopes) - 1; i >= 0; i-- {
+		decl, ok := scopes[i][name]
+		if !ok {
+			continue
+		}
+
+		base, ok := decl.(*Section)
+		if !ok {
+			return nil, nil, lexer.Errorf(section.Base.Pos(), "section %s: base %s is a %s, not a section", section.Name.Name, name, declKind(decl))
+		}
+		return base, scopes[:i+1], nil
+	}
+
+	return nil, nil, lexer.Errorf(section.Base.Pos(), "section %s: base section %s not found", section.Name.Name, name)
+}
+
+// inherit returns base's declarations that aren't overridden by one with the same name in own, followed by own.
+func inherit(base, own []Decl) []Decl {
+	overridden := make(map[string]bool, len(own))
+	for _, decl := range own {
+		overridden[DeclName(decl)] = true
+	}
+
+	body := make([]Decl, 0, len(base)+len(own))
+	for _, decl := range base {
+		if !overridden[DeclName(decl)] {
+			body = append(body, decl)
+		}
+	}
+	return append(body, own...)
+}
+
+// sectionOf returns the section a block section or section array element declares, or nil for anything else.
+func sectionOf(decl Decl) *Section {
+	switch decl := decl.(type) {
+	case *Section:
+		return decl
+	case *SectionArray:
+		return &decl.Section
+	default:
+		return nil
+	}
+}
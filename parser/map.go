@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"strconv"
+
+	"github.com/grian32/gcfg/lexer"
+	"github.com/grian32/gcfg/pair"
+)
+
+// ParseFile parses the input into nested maps, for callers that predate Parse. Integers are kept as their literal
+// string, floats become float64, pairs become pair.Pair[any, any], arrays []any, sections and inline sections
+// map[string]any and section arrays []map[string]any.
+func (p *Parser) ParseFile() (map[string]any, error) {
+	file, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return declsMap(file.Decls), nil
+}
+
+func declsMap(decls []Decl) map[string]any {
+	m := make(map[string]any)
+
+	for _, decl := range decls {
+		switch decl := decl.(type) {
+		case *Assignment:
+			m[decl.Key.Name] = valueAny(decl.Value)
+		case *SectionArray:
+			name := decl.Name.Name
+			arr, _ := m[name].([]map[string]any)
+			m[name] = append(arr, declsMap(decl.Body))
+		case *Section:
+			m[decl.Name.Name] = declsMap(decl.Body)
+		}
+	}
+
+	return m
+}
+
+func valueAny(value Value) any {
+	switch value := value.(type) {
+	case *IntLit:
+		return value.Literal
+	case *FloatLit:
+		return value.Value
+	case *StringLit:
+		return value.Value
+	case *BoolLit:
+		return value.Value
+	case *NilLit:
+		return nil
+	case *ArrayLit:
+		arr := make([]any, len(value.Elems))
+		for i, elem := range value.Elems {
+			arr[i] = valueAny(elem)
+		}
+		return arr
+	case *PairLit:
+		return pair.Pair[any, any]{
+			First:  valueAny(value.First),
+			Second: valueAny(value.Second),
+		}
+	case *SectionLit:
+		return declsMap(value.Body)
+	default:
+		return nil
+	}
+}
+
+// Positions returns where each section, section array element and value in the file starts, keyed by its path as
+// described on RefLit.
+func (f *File) Positions() map[string]lexer.Position {
+	positions := make(map[string]lexer.Position)
+	walkPaths(f.Decls, "", func(path string, node Node) {
+		positions[path] = node.Pos()
+	})
+	return positions
+}
+
+// walkPaths calls fn with every section, section array element and value in decls, and its path under path.
+func walkPaths(decls []Decl, path string, fn func(path string, node Node)) {
+	elems := make(map[string]int)
+
+	for _, decl := range decls {
+		name := DeclName(decl)
+		namePath := memberPath(path, name)
+
+		switch decl := decl.(type) {
+		case *Assignment:
+			walkValuePaths(decl.Value, namePath, fn)
+		case *Section:
+			fn(namePath, decl)
+			walkPaths(decl.Body, namePath, fn)
+		case *SectionArray:
+			elemPath := indexPath(namePath, elems[name])
+			elems[name]++
+
+			fn(elemPath, decl)
+			walkPaths(decl.Body, elemPath, fn)
+		}
+	}
+}
+
+func walkValuePaths(value Value, path string, fn func(path string, node Node)) {
+	fn(path, value)
+
+	switch value := value.(type) {
+	case *ArrayLit:
+		for i, elem := range value.Elems {
+			walkValuePaths(elem, indexPath(path, i), fn)
+		}
+	case *PairLit:
+		walkValuePaths(value.First, memberPath(path, "First"), fn)
+		walkValuePaths(value.Second, memberPath(path, "Second"), fn)
+	case *SectionLit:
+		walkPaths(value.Body, path, fn)
+	}
+}
+
+func memberPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func indexPath(path string, idx int) string {
+	return path + "[" + strconv.Itoa(idx) + "]"
+}
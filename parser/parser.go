@@ -2,206 +2,680 @@ package parser
 
 import (
 	"errors"
+	"io/fs"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/grian32/gcfg/lexer"
-	"github.com/grian32/gcfg/pair"
 )
 
 var ErrNotSimple = errors.New("value is not simple")
 
-type Parser struct {
-	l *lexer.Lexer
+// Mode controls optional parser behaviour, modes can be combined with |.
+type Mode uint
+
+const (
+	// AllowDuplicates lets a key or section be declared more than once in the same scope, with the last declaration
+	// winning. Without it that's an error, as it's usually a mistake.
+	AllowDuplicates Mode = 1 << iota
+	// MergeSections makes a section that's opened again add its declarations to the first block with its name, instead
+	// of being a duplicate. Keys declared in both blocks are still reported.
+	MergeSections
+)
 
-	curToken  lexer.Token
-	peekToken lexer.Token
+// token is a lexer token with its text copied out, as the lexer reuses the memory behind Token.Value once it moves on
+// and the parser always reads one token ahead.
+type token struct {
+	lexer.Token
+	Literal string
+	// depth is how many brackets, braces and parens are open up to and including this token, so recovering from an
+	// error can tell whether it's skipped out of what was left open.
+	depth int
+}
+
+type Parser struct {
+	l    *lexer.Lexer
+	mode Mode
+	// maxDepth is how many sections deep declarations can be nested, 1 keeps sections flat.
+	maxDepth int
+
+	curToken  token
+	peekToken token
+
+	// errors are the syntax errors found so far, the ones in illegal have already been reported by the lexer. fatal is
+	// an error the lexer couldn't recover from, such as a failed read, which ends parsing.
+	errors  lexer.ErrorList
+	illegal map[int]bool
+	fatal   error
+
+	// implicit holds the sections made up to hold dotted keys, which merge with any block of the same section.
+	implicit map[*Section]bool
+	// shapes holds the shape of each array's elements, so nested arrays aren't walked again for every array they're in.
+	shapes map[*ArrayLit]Value
+
+	// fsys is where @include reads files from, including holds the files being parsed from the top level one down to
+	// this one, to catch a file including itself.
+	fsys      fs.FS
+	including []string
 }
 
 func New(l *lexer.Lexer) *Parser {
 	return &Parser{
-		l: l,
+		l:        l,
+		maxDepth: 1,
+		illegal:  make(map[int]bool),
+		implicit: make(map[*Section]bool),
+		shapes:   make(map[*ArrayLit]Value),
 	}
 }
 
+func (p *Parser) SetMode(mode Mode) {
+	p.mode = mode
+}
+
+// SetMaxDepth lets sections and section arrays be nested inside each other up to depth levels deep. The default of 1
+// only allows them at the top level, with nothing but assignments inside.
+func (p *Parser) SetMaxDepth(depth int) {
+	p.maxDepth = max(depth, 1)
+}
+
 func (p *Parser) NextToken() error {
 	tok, err := p.l.NextToken()
+	// comments are trivia, the parser doesn't care about them even if the lexer was asked to keep them
+	for err == nil && tok.Type == lexer.COMMENT {
+		tok, err = p.l.NextToken()
+	}
 	if err != nil {
+		p.fatal = err
 		return err
 	}
 
+	if tok.Type == lexer.ILLEGAL {
+		p.illegal[tok.Pos.Offset] = true
+	}
+
+	next := token{Token: tok, Literal: tok.Literal(), depth: p.peekToken.depth}
+	switch tok.Type {
+	case lexer.LBRACE, lexer.LBRACKET, lexer.LPAREN:
+		next.depth++
+	case lexer.RBRACE, lexer.RBRACKET, lexer.RPAREN:
+		next.depth = max(next.depth-1, 0)
+	}
+
 	p.curToken = p.peekToken
-	p.peekToken = tok
+	p.peekToken = next
 	return nil
 }
 
-func (p *Parser) ParseFile() (map[string]any, error) {
-	err := p.NextToken()
+// Parse parses the whole input into a File. It doesn't stop at the first syntax error, instead it skips to the next
+// declaration and carries on, returning every error it found as a lexer.ErrorList along with what it could parse.
+// The lexer is switched to RecoverErrors so its errors are collected too.
+func (p *Parser) Parse() (*File, error) {
+	file := &File{}
+	err := p.parseDecls(&file.Decls, make(scope))
 	if err != nil {
 		return nil, err
 	}
+	file.EOF = p.curToken.Pos
+
+	p.resolveBases(file.Decls)
+	p.resolveRefs(file.Decls)
+
+	return file, p.errorList().Err()
+}
+
+// parseDecls parses the top level declarations of the whole input, adding them to decls and declared. Syntax errors
+// are recorded, the error returned is one that stops parsing altogether.
+func (p *Parser) parseDecls(decls *[]Decl, declared scope) error {
+	p.l.SetMode(p.l.Mode() | lexer.RecoverErrors)
+
+	err := p.NextToken()
+	if err != nil {
+		return err
+	}
 	err = p.NextToken()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	fileMap := make(map[string]any)
+	for p.curToken.Type != lexer.EOF {
+		start := p.curToken.Pos.Offset
 
-	for p.peekToken.Type != lexer.EOF {
-		if p.curToken.Type == lexer.IDENT {
-			if p.peekToken.Type == lexer.ASSIGN {
-				name := p.curToken.Literal
-				value, err := p.parseAssign()
-				if err != nil {
-					return nil, err
-				}
-
-				fileMap[name] = value
-			} else if p.peekToken.Type == lexer.LBRACE {
-				name := p.curToken.Literal
-				value, err := p.parseSection(false)
-				if err != nil {
-					return nil, err
-				}
-
-				fileMap[name] = value
+		if p.curToken.Type == lexer.DIRECTIVE {
+			err = p.parseDirective(decls, declared)
+		} else {
+			var decl Decl
+			decl, err = p.parseDecl(0)
+			if err == nil {
+				*decls = p.add(declared, *decls, decl)
 			}
-		} else if p.curToken.Type == lexer.LBRACKET && p.peekToken.Type == lexer.IDENT {
-			err = p.NextToken() // advance past lbracket
-			if err != nil {
-				return nil, err
+		}
+		if err != nil {
+			if p.fatal != nil {
+				return p.fatal
 			}
-			name := p.curToken.Literal
-			value, err := p.parseSection(true)
+			p.error(err)
+
+			err = p.syncDecl(start)
 			if err != nil {
-				return nil, err
+				return err
 			}
+			continue
+		}
+
+		err = p.NextToken()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-			arr, exists := fileMap[name]
+// errorList returns the errors the lexer and parser have found, in the order they appear in the input.
+func (p *Parser) errorList() lexer.ErrorList {
+	errs := slices.Concat(p.l.Errors(), p.errors)
+	errs.Sort()
+	return errs
+}
+
+// error records a syntax error, unless it's about an ILLEGAL token the lexer has already reported.
+func (p *Parser) error(err error) {
+	var posErr *lexer.Error
+	if !errors.As(err, &posErr) {
+		posErr = &lexer.Error{Pos: p.curToken.Pos, Err: err}
+	}
 
-			if exists {
-				fileMap[name] = append(arr.([]map[string]any), value)
-			} else {
-				fileMap[name] = []map[string]any{value}
+	if p.illegal[posErr.Pos.Offset] {
+		return
+	}
+	p.errors.Add(posErr)
+}
+
+// syncDecl skips what's left of a broken top level declaration that started at offset start. Once it's out of anything
+// the declaration left open, it stops at the next token that can start a declaration, an identifier followed by =, { or
+// :, a [ or a directive, or just past a }.
+func (p *Parser) syncDecl(start int) error {
+	for p.curToken.Type != lexer.EOF {
+		if p.curToken.Pos.Offset != start {
+			switch {
+			case p.curToken.Type == lexer.IDENT && p.curToken.depth == 0:
+				if startsDecl(p.peekToken.Type) || p.peekToken.Type == lexer.DOT {
+					return nil
+				}
+			case p.curToken.Type == lexer.LBRACKET && p.curToken.depth == 1: // the [ counts itself
+				return nil
+			case p.curToken.Type == lexer.DIRECTIVE && p.curToken.depth == 0:
+				return nil
 			}
 		}
 
-		err = p.NextToken()
+		closed := p.curToken.Type == lexer.RBRACE && p.curToken.depth == 0
+		err := p.NextToken()
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if closed {
+			return nil
 		}
 	}
 
-	return fileMap, nil
+	return nil
 }
 
-func (p *Parser) parseSection(arrSection bool) (map[string]any, error) {
-	err := p.NextToken() // advance past lbrace
-	if err != nil {
-		return nil, err
+// startsDecl reports whether an identifier followed by typ starts a declaration.
+func startsDecl(typ lexer.TokenType) bool {
+	return typ == lexer.ASSIGN || typ == lexer.LBRACE || typ == lexer.COLON
+}
+
+// syncAssign skips what's left of a broken statement in a section that started at offset start, stopping at the next
+// key =, Name {, [ or the } closing the section. depth is the parser's depth inside the section's braces.
+func (p *Parser) syncAssign(start int, depth int) error {
+	for p.curToken.Type != lexer.EOF {
+		if p.curToken.Type == lexer.RBRACE && p.curToken.depth < depth {
+			return nil
+		}
+		if p.curToken.Pos.Offset != start {
+			switch {
+			case p.curToken.Type == lexer.IDENT && p.curToken.depth == depth:
+				if startsDecl(p.peekToken.Type) {
+					return nil
+				}
+			case p.curToken.Type == lexer.LBRACKET && p.curToken.depth == depth+1: // the [ counts itself
+				return nil
+			}
+		}
+
+		err := p.NextToken()
+		if err != nil {
+			return err
+		}
 	}
 
-	if arrSection {
-		if p.curToken.Type != lexer.RBRACKET {
-			return nil, errors.New("expected closing ] for array section")
+	return nil
+}
+
+// parseDecl parses a declaration inside level sections, 0 being the top level.
+func (p *Parser) parseDecl(level int) (Decl, error) {
+	switch p.curToken.Type {
+	case lexer.IDENT:
+		switch p.peekToken.Type {
+		case lexer.ASSIGN:
+			return p.parseAssign()
+		case lexer.LBRACE, lexer.COLON:
+			return p.parseSection(level + 1)
+		case lexer.DOT:
+			if level == 0 {
+				return p.parseDottedAssign()
+			}
+		}
+
+		// dotted keys are only allowed at the top level
+		expected := "=, { or :"
+		if level == 0 {
+			expected = "=, {, : or ."
+		}
+		return nil, unexpected(p.peekToken, "after "+p.curToken.Literal, expected)
+	case lexer.LBRACKET:
+		return p.parseSectionArray(level + 1)
+	default:
+		return nil, unexpected(p.curToken, "", "key = value, Name { or [Name] {")
+	}
+}
+
+// parseDottedAssign parses Name.key = value, shorthand for key = value in the section Name, the parser is on the name.
+// Keys can go through as many sections as the max depth allows. The sections are made up to hold the assignment, and
+// add merges them with any other block of the same sections.
+func (p *Parser) parseDottedAssign() (Decl, error) {
+	path := []*Ident{p.ident()}
+	dotted := p.curToken.Literal
+
+	for p.peekToken.Type == lexer.DOT {
+		err := p.NextToken() // advance to dot
+		if err != nil {
+			return nil, err
+		}
+		if p.peekToken.Type != lexer.IDENT {
+			return nil, unexpected(p.peekToken, "after "+dotted+".", "key name")
 		}
-		err := p.NextToken() // advance past ]
+		err = p.NextToken() // advance to name
 		if err != nil {
 			return nil, err
 		}
+
+		path = append(path, p.ident())
+		dotted += "." + p.curToken.Literal
 	}
 
-	err = p.NextToken() // advance to first
+	if p.peekToken.Type != lexer.ASSIGN {
+		return nil, unexpected(p.peekToken, "after "+dotted, "=")
+	}
+	if len(path)-1 > p.maxDepth {
+		return nil, lexer.Errorf(path[0].Pos(), "key %s: nesting past %s not allowed", dotted, levels(p.maxDepth))
+	}
+
+	assign, err := p.parseAssign()
+	if err != nil {
+		return nil, err
+	}
+
+	var decl Decl = assign
+	for i := len(path) - 2; i >= 0; i-- {
+		section := &Section{Name: path[i], Body: []Decl{decl}}
+		p.implicit[section] = true
+		decl = section
+	}
+	return decl, nil
+}
+
+// parseSectionArray parses [Name] { ... } at the given nesting level, the parser is on the [.
+func (p *Parser) parseSectionArray(level int) (*SectionArray, error) {
+	arr := &SectionArray{Lbrack: p.curToken.Pos}
+
+	if p.peekToken.Type != lexer.IDENT {
+		return nil, unexpected(p.peekToken, "after [", "section array name")
+	}
+	err := p.NextToken() // advance past lbracket
 	if err != nil {
 		return nil, err
 	}
 
-	sectionMap := make(map[string]any)
+	if p.peekToken.Type != lexer.RBRACKET {
+		return nil, lexer.Errorf(p.peekToken.Pos, "expected closing ] for array section")
+	}
+	arr.Name = p.ident()
+	err = p.NextToken() // advance past name
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.parseBase(&arr.Section)
+	if err != nil {
+		return nil, err
+	}
+	err = p.parseSectionBody(&arr.Section, level)
+	if err != nil {
+		return nil, err
+	}
+	return arr, nil
+}
+
+// parseSection parses Name { ... } at the given nesting level, the parser is on the name.
+func (p *Parser) parseSection(level int) (*Section, error) {
+	section := &Section{Name: p.ident()}
+	err := p.parseBase(section)
+	if err != nil {
+		return nil, err
+	}
+	err = p.parseSectionBody(section, level)
+	if err != nil {
+		return nil, err
+	}
+	return section, nil
+}
+
+// parseBase parses the : Base that can follow a section's name, the parser is on the token before the colon and
+// finishes on the base's name if there is one.
+func (p *Parser) parseBase(section *Section) error {
+	if p.peekToken.Type != lexer.COLON {
+		return nil
+	}
+	err := p.NextToken() // advance to colon
+	if err != nil {
+		return err
+	}
+	section.Colon = p.curToken.Pos
+
+	if p.peekToken.Type != lexer.IDENT {
+		return unexpected(p.peekToken, "after :", "base section name")
+	}
+	err = p.NextToken() // advance to base
+	if err != nil {
+		return err
+	}
+	section.Base = p.ident()
+
+	return nil
+}
+
+// parseSectionBody parses the { ... } following the name of a section at the given nesting level, the parser is on the
+// token before the {. Sections and section arrays can go in the body while level is under the max depth.
+func (p *Parser) parseSectionBody(section *Section, level int) error {
+	err := p.NextToken() // advance to lbrace
+	if err != nil {
+		return err
+	}
+	if p.curToken.Type != lexer.LBRACE {
+		return lexer.Errorf(p.curToken.Pos, "expected { to open section %s", section.Name.Name)
+	}
+	section.Lbrace = p.curToken.Pos
+	depth := p.curToken.depth
+
+	err = p.NextToken() // advance to first
+	if err != nil {
+		return err
+	}
+
+	declared := make(scope)
+
+	expected := "key = value or }"
+	if level < p.maxDepth {
+		expected = "key = value, Name {, [Name] { or }"
+	}
 
 	for p.curToken.Type != lexer.RBRACE {
-		if p.curToken.Type == lexer.IDENT && p.peekToken.Type == lexer.ASSIGN {
-			name := p.curToken.Literal
-			value, err := p.parseAssign()
-			if err != nil {
-				return nil, err
+		if p.curToken.Type == lexer.EOF {
+			return unexpected(p.curToken, "in section "+section.Name.Name, expected)
+		}
+		start := p.curToken.Pos.Offset
+
+		// errors in here are recorded and skipped past straight away so the rest of the section still gets checked
+		var err error
+		switch {
+		case p.curToken.Type == lexer.IDENT && p.peekToken.Type == lexer.ASSIGN:
+			var assign *Assignment
+			assign, err = p.parseAssign()
+			if err == nil {
+				section.Body = p.add(declared, section.Body, assign)
+			}
+		case p.curToken.Type == lexer.IDENT && (p.peekToken.Type == lexer.LBRACE || p.peekToken.Type == lexer.COLON),
+			p.curToken.Type == lexer.LBRACKET:
+			if level >= p.maxDepth {
+				name := p.curToken.Literal
+				if p.curToken.Type == lexer.LBRACKET {
+					name = p.peekToken.Literal
+				}
+				err = lexer.Errorf(p.curToken.Pos, "section %s: nesting past %s not allowed", name, levels(p.maxDepth))
+				break
 			}
 
-			sectionMap[name] = value
-		} else {
-			return nil, errors.New("something other than assignments found in section")
+			var decl Decl
+			decl, err = p.parseDecl(level)
+			if err == nil {
+				section.Body = p.add(declared, section.Body, decl)
+			}
+		default:
+			err = unexpected(p.curToken, "in section "+section.Name.Name, expected)
+		}
+
+		if err != nil {
+			if p.fatal != nil {
+				return p.fatal
+			}
+			p.error(err)
+
+			err = p.syncAssign(start, depth)
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
 		err = p.NextToken()
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
+	section.Rbrace = p.curToken.Pos
 
-	return sectionMap, nil
+	return nil
 }
 
-func (p *Parser) parseAssign() (any, error) {
-	err := p.NextToken() // advance past =
+// parseAssign parses key = value, the parser is on the key and finishes on the last token of the value.
+func (p *Parser) parseAssign() (*Assignment, error) {
+	assign := &Assignment{Key: p.ident()}
+
+	err := p.NextToken() // advance to =
 	if err != nil {
 		return nil, err
 	}
+	assign.Assign = p.curToken.Pos
+
 	err = p.NextToken() // advance to value
 	if err != nil {
 		return nil, err
 	}
 
-	return p.parseValue()
+	assign.Value, err = p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return assign, nil
 }
 
-func (p *Parser) parseSimpleValue() (any, error) {
-	var val any
+// scope holds what's been declared so far in a file or section body, by name.
+type scope map[string]Decl
+
+// add declares decl in sc and appends it to body, recording the error if it's a duplicate. With MergeSections, or when
+// either of them holds dotted keys, a section that's already in sc has decl's declarations merged into it instead, and
+// body is left as it is.
+func (p *Parser) add(sc scope, body []Decl, decl Decl) []Decl {
+	if section, ok := decl.(*Section); ok {
+		prev, ok := sc[section.Name.Name].(*Section)
+		if ok && (p.mode&MergeSections != 0 || p.implicit[prev] || p.implicit[section]) {
+			p.merge(prev, section)
+			return body
+		}
+	}
 
-	switch p.curToken.Type {
-	case lexer.INT, lexer.STRING:
-		val = p.curToken.Literal
+	err := p.declare(sc, decl)
+	if err != nil {
+		p.error(err)
+	}
+	return append(body, decl)
+}
+
+// merge adds the declarations of reopened to section, the block it opens again, merging any sections in both the
+// same way.
+func (p *Parser) merge(section, reopened *Section) {
+	// a block merged into a section made up for dotted keys takes its place, so a second block is a duplicate again
+	if p.implicit[section] && !p.implicit[reopened] {
+		delete(p.implicit, section)
+		section.Lbrace, section.Rbrace = reopened.Lbrace, reopened.Rbrace
+	}
+	if reopened.Base != nil {
+		if section.Base == nil {
+			section.Colon, section.Base = reopened.Colon, reopened.Base
+		} else if section.Base.Name != reopened.Base.Name {
+			p.error(lexer.Errorf(reopened.Base.Pos(), "section %s: base %s conflicts with base %s at %s",
+				section.Name.Name, reopened.Base.Name, section.Base.Name, section.Base.Pos()))
+		}
+	}
+
+	declared := make(scope, len(section.Body))
+	for _, decl := range section.Body {
+		declared[DeclName(decl)] = decl
+	}
+	for _, decl := range reopened.Body {
+		section.Body = p.add(declared, section.Body, decl)
+	}
+}
+
+// declare adds decl to sc, failing if its name is already taken unless AllowDuplicates is set. The blocks of a section
+// array all share a name, so they don't count as duplicates of each other.
+func (p *Parser) declare(sc scope, decl Decl) error {
+	name := DeclName(decl)
+	prev, exists := sc[name]
+	sc[name] = decl
+
+	if !exists || p.mode&AllowDuplicates != 0 {
+		return nil
+	}
+
+	kind, prevKind := declKind(decl), declKind(prev)
+	if kind == prevKind {
+		if _, ok := decl.(*SectionArray); ok {
+			return nil
+		}
+		return lexer.Errorf(decl.Pos(), "duplicate %s %s, first defined at %s", kind, name, prev.Pos())
+	}
+	return lexer.Errorf(decl.Pos(), "%s %s conflicts with %s defined at %s", kind, name, prevKind, prev.Pos())
+}
+
+// levels formats a nesting depth for error messages.
+func levels(n int) string {
+	if n == 1 {
+		return "1 level"
+	}
+	return strconv.Itoa(n) + " levels"
+}
+
+// unexpected reports tok as not belonging where it is, context says where that is and expected what could go there.
+func unexpected(tok token, context string, expected string) error {
+	if context != "" {
+		context = " " + context
+	}
+	return lexer.Errorf(tok.Pos, "unexpected %s%s, expected %s", describe(tok), context, expected)
+}
+
+// describe names a token for error messages.
+func describe(tok token) string {
+	switch tok.Type {
+	case lexer.EOF:
+		return "end of file"
+	case lexer.IDENT:
+		return "identifier " + tok.Literal
+	case lexer.INT, lexer.FLOAT:
+		return "number " + tok.Literal
+	case lexer.STRING:
+		return "string " + strconv.Quote(tok.Literal)
+	case lexer.TRUE, lexer.FALSE, lexer.NULL:
+		return tok.Literal
+	case lexer.DIRECTIVE:
+		return "directive " + tok.Literal
+	case lexer.REFERENCE:
+		return "reference " + tok.Literal
+	default:
+		return "'" + tok.Literal + "'"
+	}
+}
+
+func (p *Parser) ident() *Ident {
+	return &Ident{NamePos: p.curToken.Pos, NameEnd: p.curToken.End, Name: p.curToken.Literal}
+}
+
+func (p *Parser) parseSimpleValue() (Value, error) {
+	tok := p.curToken
+
+	switch tok.Type {
+	case lexer.INT:
+		return &IntLit{ValuePos: tok.Pos, ValueEnd: tok.End, Literal: tok.Literal}, nil
+	case lexer.STRING:
+		return &StringLit{ValuePos: tok.Pos, ValueEnd: tok.End, Value: tok.Literal, Raw: tok.Raw}, nil
 	case lexer.FLOAT:
-		value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+		literal := strings.ReplaceAll(tok.Literal, "_", "")
+		// ParseFloat doesn't take a sign on nan, which is the same either way
+		if unsigned := strings.TrimLeft(literal, "+-"); unsigned == "nan" {
+			literal = unsigned
+		}
+		value, err := strconv.ParseFloat(literal, 64)
 		if err != nil {
-			return nil, err
+			return nil, lexer.Errorf(tok.Pos, "%w", err)
 		}
-		val = value
+		return &FloatLit{ValuePos: tok.Pos, ValueEnd: tok.End, Literal: tok.Literal, Value: value}, nil
 	case lexer.TRUE:
-		val = true
+		return &BoolLit{ValuePos: tok.Pos, ValueEnd: tok.End, Value: true}, nil
 	case lexer.FALSE:
-		val = false
+		return &BoolLit{ValuePos: tok.Pos, ValueEnd: tok.End, Value: false}, nil
 	case lexer.NULL:
-		val = nil
+		return &NilLit{ValuePos: tok.Pos, ValueEnd: tok.End}, nil
+	case lexer.REFERENCE:
+		path := strings.TrimSpace(tok.Literal[len("${") : len(tok.Literal)-len("}")])
+		return &RefLit{ValuePos: tok.Pos, ValueEnd: tok.End, Path: path}, nil
 	default:
 		return nil, ErrNotSimple
 	}
-
-	return val, nil
 }
 
-func (p *Parser) parsePair() (any, error) {
+func (p *Parser) parsePair() (*PairLit, error) {
+	pairLit := &PairLit{Lparen: p.curToken.Pos}
+
 	err := p.NextToken() // advance past lparen
 	if err != nil {
 		return nil, err
 	}
 
-	first, err := p.parseSimpleValue()
+	pairLit.First, err = p.parseValue()
 	if err != nil {
 		return nil, err
 	}
 
 	err = p.NextToken()
-	if p.curToken.Type != lexer.COMMA {
-		return nil, errors.New("expected comma after value in pair")
-	}
 	if err != nil {
 		return nil, err
 	}
+	if p.curToken.Type != lexer.COMMA {
+		return nil, lexer.Errorf(p.curToken.Pos, "expected comma after value in pair")
+	}
 
 	err = p.NextToken() // advance past comma
 	if err != nil {
 		return nil, err
 	}
 
-	second, err := p.parseSimpleValue()
+	pairLit.Second, err = p.parseValue()
 	if err != nil {
 		return nil, err
 	}
@@ -210,74 +684,216 @@ func (p *Parser) parsePair() (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	// a trailing comma is allowed, like in arrays
+	if p.curToken.Type == lexer.COMMA {
+		err = p.NextToken()
+		if err != nil {
+			return nil, err
+		}
+	}
 	if p.curToken.Type != lexer.RPAREN {
-		return nil, errors.New("expected rparen after second value in pair")
+		return nil, lexer.Errorf(p.curToken.Pos, "expected rparen after second value in pair")
 	}
+	pairLit.Rparen = p.curToken.Pos
 
-	return pair.Pair[any, any]{
-		First:  first,
-		Second: second,
-	}, nil
+	return pairLit, nil
 }
 
-func (p *Parser) parseArray() (any, error) {
+func (p *Parser) parseArray() (*ArrayLit, error) {
+	arr := &ArrayLit{Lbrack: p.curToken.Pos, Elems: []Value{}}
+
 	err := p.NextToken() // advance past lbracket
 	if err != nil {
 		return nil, err
 	}
 
-	if p.curToken.Type == lexer.RBRACKET {
-		return []any{}, nil
-	}
+	var shape Value
+	// the value after each comma is optional, so a trailing comma before the ] is fine
+	for p.curToken.Type != lexer.RBRACKET {
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
 
-	first, err := p.parseSimpleValue()
-	if err != nil {
-		return nil, err
-	}
-	firstType := p.curToken.Type
+		valShape := p.shapeOf(val)
+		if shape != nil && !sameShape(shape, valShape) {
+			return nil, lexer.Errorf(val.Pos(), "arrays must be of single type")
+		}
+		shape = mergeShapes(shape, valShape)
 
-	err = p.NextToken()
-	if p.curToken.Type == lexer.RBRACKET {
-		return []any{first}, nil
-	} else if p.curToken.Type != lexer.COMMA {
-		return nil, errors.New("expected comma after value in array")
+		arr.Elems = append(arr.Elems, val)
+
+		err = p.NextToken() // adv to comma
+		if err != nil {
+			return nil, err
+		}
+		if p.curToken.Type == lexer.RBRACKET {
+			break
+		} else if p.curToken.Type != lexer.COMMA {
+			return nil, lexer.Errorf(p.curToken.Pos, "expected comma after value in array")
+		}
+
+		err = p.NextToken() // advance past comma
+		if err != nil {
+			return nil, err
+		}
 	}
+	arr.Rbrack = p.curToken.Pos
+	p.shapes[arr] = shape
+
+	return arr, nil
+}
+
+// parseSectionLit parses an inline section, { key = value, ... }, the parser is on the {. Like arrays, entries are
+// separated by commas and a trailing comma is allowed.
+func (p *Parser) parseSectionLit() (*SectionLit, error) {
+	lit := &SectionLit{Lbrace: p.curToken.Pos}
+
+	err := p.NextToken() // advance past lbrace
 	if err != nil {
 		return nil, err
 	}
 
-	arr := []any{first}
+	declared := make(scope)
 
-	for p.curToken.Type != lexer.RBRACKET {
-		if p.curToken.Type != lexer.COMMA {
-			return nil, errors.New("expected comma after value in array")
+	for p.curToken.Type != lexer.RBRACE {
+		if p.curToken.Type != lexer.IDENT || p.peekToken.Type != lexer.ASSIGN {
+			return nil, unexpected(p.curToken, "in inline section", "key = value or }")
 		}
 
-		err = p.NextToken() // advance past comma
+		assign, err := p.parseAssign()
 		if err != nil {
 			return nil, err
 		}
-
-		val, err := p.parseSimpleValue()
-		if p.curToken.Type != firstType {
-			return nil, errors.New("arrays must be of single type")
-		}
+		err = p.declare(declared, assign)
 		if err != nil {
 			return nil, err
 		}
-
-		arr = append(arr, val)
+		lit.Body = append(lit.Body, assign)
 
 		err = p.NextToken() // adv to comma
 		if err != nil {
 			return nil, err
 		}
+		if p.curToken.Type == lexer.RBRACE {
+			break
+		} else if p.curToken.Type != lexer.COMMA {
+			return nil, unexpected(p.curToken, "after value in inline section", ", or }")
+		}
+
+		err = p.NextToken() // advance past comma
+		if err != nil {
+			return nil, err
+		}
 	}
+	lit.Rbrace = p.curToken.Pos
 
-	return arr, nil
+	return lit, nil
+}
+
+// shapeOf returns the shape of v as an array element, which sameShape compares against the other elements. The shape
+// of an array has a single element, the shape of all of its elements merged together, so comparing it compares every
+// element.
+func (p *Parser) shapeOf(v Value) Value {
+	switch v := v.(type) {
+	case *PairLit:
+		return &PairLit{First: p.shapeOf(v.First), Second: p.shapeOf(v.Second)}
+	case *ArrayLit:
+		inner, ok := p.shapes[v]
+		if !ok {
+			for _, elem := range v.Elems {
+				inner = mergeShapes(inner, p.shapeOf(elem))
+			}
+		}
+		if inner == nil {
+			return &ArrayLit{}
+		}
+		return &ArrayLit{Elems: []Value{inner}}
+	default:
+		return v
+	}
+}
+
+// mergeShapes returns shape with what elem, the shape of another element of the same array, says about the elements
+// added to it. shape is nil before the first element. An empty array doesn't say what its elements are, nor a reference
+// what it is, so they're filled in by later elements, however deep inside them they are.
+func mergeShapes(shape, elem Value) Value {
+	if _, ok := shape.(*RefLit); shape == nil || ok {
+		return elem
+	}
+
+	switch elem := elem.(type) {
+	case *PairLit:
+		pair, ok := shape.(*PairLit)
+		if !ok {
+			return shape
+		}
+		return &PairLit{First: mergeShapes(pair.First, elem.First), Second: mergeShapes(pair.Second, elem.Second)}
+	case *ArrayLit:
+		arr, ok := shape.(*ArrayLit)
+		if !ok || len(elem.Elems) == 0 {
+			return shape
+		}
+		var inner Value
+		if len(arr.Elems) > 0 {
+			inner = arr.Elems[0]
+		}
+		return &ArrayLit{Elems: []Value{mergeShapes(inner, elem.Elems[0])}}
+	default:
+		return shape
+	}
+}
+
+// sameShape reports whether a and b can be elements of the same array. Simple values and inline sections need the same
+// kind, pairs need the same shape in each half and arrays need the same element shape, with an empty array fitting any
+// other array.
+func sameShape(a, b Value) bool {
+	// what a reference refers to isn't known yet, so arrays are checked again once references are resolved
+	if _, ok := a.(*RefLit); ok {
+		return true
+	}
+	if _, ok := b.(*RefLit); ok {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *IntLit:
+		_, ok := b.(*IntLit)
+		return ok
+	case *FloatLit:
+		_, ok := b.(*FloatLit)
+		return ok
+	case *StringLit:
+		_, ok := b.(*StringLit)
+		return ok
+	case *BoolLit:
+		_, ok := b.(*BoolLit)
+		return ok
+	case *NilLit:
+		_, ok := b.(*NilLit)
+		return ok
+	case *PairLit:
+		b, ok := b.(*PairLit)
+		return ok && sameShape(a.First, b.First) && sameShape(a.Second, b.Second)
+	case *SectionLit:
+		// the keys are checked against the struct they're decoded into, not each other
+		_, ok := b.(*SectionLit)
+		return ok
+	case *ArrayLit:
+		b, ok := b.(*ArrayLit)
+		if !ok {
+			return false
+		}
+		if len(a.Elems) == 0 || len(b.Elems) == 0 {
+			return true
+		}
+		return sameShape(a.Elems[0], b.Elems[0])
+	default:
+		return false
+	}
 }
 
-func (p *Parser) parseValue() (any, error) {
+func (p *Parser) parseValue() (Value, error) {
 	simple, err := p.parseSimpleValue()
 	if err != nil && !errors.Is(err, ErrNotSimple) {
 		return nil, err
@@ -289,8 +905,10 @@ func (p *Parser) parseValue() (any, error) {
 			return p.parsePair()
 		case lexer.LBRACKET:
 			return p.parseArray()
+		case lexer.LBRACE:
+			return p.parseSectionLit()
 		default:
-			return nil, errors.New("invalid value")
+			return nil, lexer.Errorf(p.curToken.Pos, "invalid value")
 		}
 	} else {
 		return simple, nil
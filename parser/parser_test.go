@@ -1,8 +1,12 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/grian32/gcfg/lexer"
 	"github.com/grian32/gcfg/pair"
@@ -72,3 +76,1280 @@ Sec {
 		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expectedOutput)
 	}
 }
+
+func TestParseComments(t *testing.T) {
+	input := `
+# comments are skipped
+x = 3 // anywhere
+
+Sec {
+	b = 4 /* even
+	across lines */
+	hi = true
+}
+`
+	expected := map[string]any{
+		"x":   "3",
+		"Sec": map[string]any{"b": "4", "hi": true},
+	}
+
+	p := New(lexer.New([]byte(input)))
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+}
+
+func TestParseIdentifiers(t *testing.T) {
+	input := `
+Sec-2 {
+	key2 = 1
+	max-conns = 10
+	größe = 3
+}
+`
+	expected := map[string]any{
+		"Sec-2": map[string]any{"key2": "1", "max-conns": "10", "größe": "3"},
+	}
+
+	p := New(lexer.New([]byte(input)))
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := `b = 2
+a = [1, 2]
+Sec {
+	s = "x"
+}
+[Arr] {
+	p = (1.5, nil)
+}
+`
+
+	pos := func(offset, line, col int) lexer.Position {
+		return lexer.Position{Filename: "test.gcfg", Offset: offset, Line: line, Column: col}
+	}
+
+	expected := &File{
+		Decls: []Decl{
+			&Assignment{
+				Key:    &Ident{NamePos: pos(0, 1, 1), NameEnd: pos(1, 1, 2), Name: "b"},
+				Assign: pos(2, 1, 3),
+				Value:  &IntLit{ValuePos: pos(4, 1, 5), ValueEnd: pos(5, 1, 6), Literal: "2"},
+			},
+			&Assignment{
+				Key:    &Ident{NamePos: pos(6, 2, 1), NameEnd: pos(7, 2, 2), Name: "a"},
+				Assign: pos(8, 2, 3),
+				Value: &ArrayLit{
+					Lbrack: pos(10, 2, 5),
+					Elems: []Value{
+						&IntLit{ValuePos: pos(11, 2, 6), ValueEnd: pos(12, 2, 7), Literal: "1"},
+						&IntLit{ValuePos: pos(14, 2, 9), ValueEnd: pos(15, 2, 10), Literal: "2"},
+					},
+					Rbrack: pos(15, 2, 10),
+				},
+			},
+			&Section{
+				Name:   &Ident{NamePos: pos(17, 3, 1), NameEnd: pos(20, 3, 4), Name: "Sec"},
+				Lbrace: pos(21, 3, 5),
+				Body: []Decl{
+					&Assignment{
+						Key:    &Ident{NamePos: pos(24, 4, 2), NameEnd: pos(25, 4, 3), Name: "s"},
+						Assign: pos(26, 4, 4),
+						Value:  &StringLit{ValuePos: pos(28, 4, 6), ValueEnd: pos(31, 4, 9), Value: "x"},
+					},
+				},
+				Rbrace: pos(32, 5, 1),
+			},
+			&SectionArray{
+				Lbrack: pos(34, 6, 1),
+				Section: Section{
+					Name:   &Ident{NamePos: pos(35, 6, 2), NameEnd: pos(38, 6, 5), Name: "Arr"},
+					Lbrace: pos(40, 6, 7),
+					Body: []Decl{
+						&Assignment{
+							Key:    &Ident{NamePos: pos(43, 7, 2), NameEnd: pos(44, 7, 3), Name: "p"},
+							Assign: pos(45, 7, 4),
+							Value: &PairLit{
+								Lparen: pos(47, 7, 6),
+								First:  &FloatLit{ValuePos: pos(48, 7, 7), ValueEnd: pos(51, 7, 10), Literal: "1.5", Value: 1.5},
+								Second: &NilLit{ValuePos: pos(53, 7, 12), ValueEnd: pos(56, 7, 15)},
+								Rparen: pos(56, 7, 15),
+							},
+						},
+					},
+					Rbrace: pos(58, 8, 1),
+				},
+			},
+		},
+		EOF: pos(60, 9, 1),
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	file, err := p.Parse()
+
+	if err != nil || !reflect.DeepEqual(expected, file) {
+		t.Errorf("Parse=%#v, %v, wanted match for %#v", file, err, expected)
+	}
+
+	arr := file.Decls[3]
+	if arr.Pos() != pos(34, 6, 1) || arr.End() != pos(59, 8, 2) {
+		t.Errorf("SectionArray span=%v-%v, wanted 6:1-8:2", arr.Pos(), arr.End())
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Key",
+			input:    "port = 1\nport = 2",
+			expected: "test.gcfg:2:1: duplicate key port, first defined at test.gcfg:1:1",
+		},
+		{
+			name:     "KeyInSection",
+			input:    "Server {\n\tport = 1\n\tport = 2\n}",
+			expected: "test.gcfg:3:2: duplicate key port, first defined at test.gcfg:2:2",
+		},
+		{
+			name:     "Section",
+			input:    "Server {\n}\nServer {\n}",
+			expected: "test.gcfg:3:1: duplicate section Server, first defined at test.gcfg:1:1",
+		},
+		{
+			name:     "KeyAndSection",
+			input:    "Server = 1\nServer {\n}",
+			expected: "test.gcfg:2:1: section Server conflicts with key defined at test.gcfg:1:1",
+		},
+		{
+			name:     "SectionAndSectionArray",
+			input:    "[Server] {\n}\nServer {\n}",
+			expected: "test.gcfg:3:1: section Server conflicts with section array defined at test.gcfg:1:1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAllowDuplicates(t *testing.T) {
+	input := `
+port = 1
+port = 2
+Server {
+	host = "a"
+	host = "b"
+}
+Server {
+	host = "c"
+}
+`
+
+	expectedOutput := map[string]any{
+		"port": "2",
+		"Server": map[string]any{
+			"host": "c",
+		},
+	}
+
+	p := New(lexer.New([]byte(input)))
+	p.SetMode(AllowDuplicates)
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(expectedOutput, output) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expectedOutput)
+	}
+}
+
+func TestUnexpectedTopLevelTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "BareIdent",
+			input:    "a = 1\nport\nb = 2",
+			expected: "test.gcfg:3:1: unexpected identifier b after port, expected =, {, : or .",
+		},
+		{
+			name:     "TrailingIdent",
+			input:    "a = 1\nport",
+			expected: "test.gcfg:2:5: unexpected end of file after port, expected =, {, : or .",
+		},
+		{
+			name:     "LoneValue",
+			input:    "3",
+			expected: "test.gcfg:1:1: unexpected number 3, expected key = value, Name { or [Name] {",
+		},
+		{
+			name:     "IdentThenValue",
+			input:    `name "x"`,
+			expected: `test.gcfg:1:6: unexpected string "x" after name, expected =, {, : or .`,
+		},
+		{
+			name:     "StrayPunctuation",
+			input:    "a = 1\n}",
+			expected: "test.gcfg:2:1: unexpected '}', expected key = value, Name { or [Name] {",
+		},
+		{
+			name:     "SectionArrayWithoutName",
+			input:    "[1] {\n}",
+			expected: "test.gcfg:1:2: unexpected number 1 after [, expected section array name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:  "BrokenAssignments",
+			input: "a = \nb = 2\nc = [1, \"x\"]\nd = 4",
+			expected: []string{
+				"test.gcfg:2:1: invalid value",
+				"test.gcfg:3:9: arrays must be of single type",
+			},
+		},
+		{
+			name:  "BrokenSections",
+			input: "Server {\n\tport 1\n\thost = \"x\"\n\t3\n}\n[Db] {\n\tname = \n}\nlast = 1",
+			expected: []string{
+				"test.gcfg:2:2: unexpected identifier port in section Server, expected key = value or }",
+				"test.gcfg:4:2: unexpected number 3 in section Server, expected key = value or }",
+				"test.gcfg:8:1: invalid value",
+			},
+		},
+		{
+			name:  "LexerErrors",
+			input: "a = $\nb = 1\nc = 1.2.3",
+			expected: []string{
+				"test.gcfg:1:5: illegal character '$'",
+				"test.gcfg:3:5: multiple dots not allowed in number",
+			},
+		},
+		{
+			name:  "Duplicates",
+			input: "a = 1\na = 2\nb c\nb = 3",
+			expected: []string{
+				"test.gcfg:2:1: duplicate key a, first defined at test.gcfg:1:1",
+				"test.gcfg:3:3: unexpected identifier c after b, expected =, {, : or .",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			var errs lexer.ErrorList
+			if !errors.As(err, &errs) {
+				t.Fatalf("Parse error=%v, wanted an ErrorList", err)
+			}
+
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Parse errors=%q, wanted %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompoundArrays(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+	}{
+		{
+			name:  "Pairs",
+			input: `a = [("/a", 1), ("/b", 2)]`,
+			expected: []any{
+				pair.Pair[any, any]{First: "/a", Second: "1"},
+				pair.Pair[any, any]{First: "/b", Second: "2"},
+			},
+		},
+		{
+			name:     "Nested",
+			input:    "a = [[1, 2], [3, 4]]",
+			expected: []any{[]any{"1", "2"}, []any{"3", "4"}},
+		},
+		{
+			name:     "NestedEmpty",
+			input:    "a = [[], [1], []]",
+			expected: []any{[]any{}, []any{"1"}, []any{}},
+		},
+		{
+			name:  "PairOfArray",
+			input: `a = [("name", [1, 2]), ("other", [])]`,
+			expected: []any{
+				pair.Pair[any, any]{First: "name", Second: []any{"1", "2"}},
+				pair.Pair[any, any]{First: "other", Second: []any{}},
+			},
+		},
+		{
+			name:  "PairOfPairs",
+			input: "a = [((1, 2), (3, 4))]",
+			expected: []any{
+				pair.Pair[any, any]{
+					First:  pair.Pair[any, any]{First: "1", Second: "2"},
+					Second: pair.Pair[any, any]{First: "3", Second: "4"},
+				},
+			},
+		},
+		{
+			name:     "Bools",
+			input:    "a = [true, false]",
+			expected: []any{true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New([]byte(tt.input)))
+			output, err := p.ParseFile()
+
+			if err != nil || !reflect.DeepEqual(output["a"], tt.expected) {
+				t.Errorf("ParseFile=%v, %v, wanted match for %v", output["a"], err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestArrayShapeMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "PairAndInt",
+			input:    `a = [(1, 2), 3]`,
+			expected: "test.gcfg:1:14: arrays must be of single type",
+		},
+		{
+			name:     "PairHalves",
+			input:    `a = [("/a", 1), ("/b", "x")]`,
+			expected: "test.gcfg:1:17: arrays must be of single type",
+		},
+		{
+			name:     "NestedElements",
+			input:    "a = [[1, 2], [\"x\"]]",
+			expected: "test.gcfg:1:14: arrays must be of single type",
+		},
+		{
+			name:     "AfterEmpty",
+			input:    "a = [[], [1], [\"x\"]]",
+			expected: "test.gcfg:1:15: arrays must be of single type",
+		},
+		{
+			name:     "PairSecondArray",
+			input:    `a = [("a", [1]), ("b", ["x"])]`,
+			expected: "test.gcfg:1:18: arrays must be of single type",
+		},
+		{
+			name:     "ArrayInPair",
+			input:    `a = ("a", [1, "x"])`,
+			expected: "test.gcfg:1:15: arrays must be of single type",
+		},
+		{
+			name:     "InnerArray",
+			input:    "a = [[1, \"x\"]]",
+			expected: "test.gcfg:1:10: arrays must be of single type",
+		},
+		{
+			name:     "EmptyArrayInPair",
+			input:    `a = [(1, []), (2, ["a"]), (3, [1])]`,
+			expected: "test.gcfg:1:27: arrays must be of single type",
+		},
+		{
+			name:     "EmptyArrayInArray",
+			input:    `a = [[[]], [[1]], [["a"]]]`,
+			expected: "test.gcfg:1:19: arrays must be of single type",
+		},
+		{
+			name:     "EmptyArrayFirstInElement",
+			input:    `a = [[[], [1]], [["a"]]]`,
+			expected: "test.gcfg:1:17: arrays must be of single type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrailingCommas(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+	}{
+		{
+			name:     "Array",
+			input:    "a = [1, 2,]",
+			expected: []any{"1", "2"},
+		},
+		{
+			name:     "SingleElement",
+			input:    `a = ["x",]`,
+			expected: []any{"x"},
+		},
+		{
+			name:     "Pair",
+			input:    "a = (1, 2,)",
+			expected: pair.Pair[any, any]{First: "1", Second: "2"},
+		},
+		{
+			name: "Multiline",
+			input: `a = [
+	# the first one
+	"one",
+	"two", // the second one
+	/* and a third */ "three",
+]`,
+			expected: []any{"one", "two", "three"},
+		},
+		{
+			name: "MultilineNested",
+			input: `a = [
+	[1, 2,],
+	[
+		3,
+		4,
+	],
+]`,
+			expected: []any{[]any{"1", "2"}, []any{"3", "4"}},
+		},
+		{
+			name: "MultilinePairs",
+			input: `a = [
+	("/a", 1),
+	(
+		"/b",
+		2,
+	),
+]`,
+			expected: []any{
+				pair.Pair[any, any]{First: "/a", Second: "1"},
+				pair.Pair[any, any]{First: "/b", Second: "2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New([]byte(tt.input)))
+			output, err := p.ParseFile()
+
+			if err != nil || !reflect.DeepEqual(output["a"], tt.expected) {
+				t.Errorf("ParseFile=%v, %v, wanted match for %v", output["a"], err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBadCommas(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "OnlyComma",
+			input:    "a = [,]",
+			expected: "test.gcfg:1:6: invalid value",
+		},
+		{
+			name:     "DoubleComma",
+			input:    "a = [1,, 2]",
+			expected: "test.gcfg:1:8: invalid value",
+		},
+		{
+			name:     "MissingComma",
+			input:    "a = [\n\t1\n\t2\n]",
+			expected: "test.gcfg:3:2: expected comma after value in array",
+		},
+		{
+			name:     "PairDoubleComma",
+			input:    "a = (1, 2,,)",
+			expected: "test.gcfg:1:11: expected rparen after second value in pair",
+		},
+		{
+			name:     "PairThirdValue",
+			input:    "a = (1, 2, 3)",
+			expected: "test.gcfg:1:12: expected rparen after second value in pair",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSectionLit(t *testing.T) {
+	input := `origin = { x = 1, y = (2, 3), }
+empty = {}
+points = [
+	{ x = 1 },
+	{ x = 2 },
+]`
+
+	expected := map[string]any{
+		"origin": map[string]any{
+			"x": "1",
+			"y": pair.Pair[any, any]{First: "2", Second: "3"},
+		},
+		"empty":  map[string]any{},
+		"points": []any{map[string]any{"x": "1"}, map[string]any{"x": "2"}},
+	}
+
+	p := New(lexer.New([]byte(input)))
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+}
+
+func TestSectionLitErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "MissingComma",
+			input:    "a = { x = 1 y = 2 }",
+			expected: "test.gcfg:1:13: unexpected identifier y after value in inline section, expected , or }",
+		},
+		{
+			name:     "NotAssignment",
+			input:    "a = { 1 }",
+			expected: "test.gcfg:1:7: unexpected number 1 in inline section, expected key = value or }",
+		},
+		{
+			name:     "Duplicate",
+			input:    "a = { x = 1, x = 2 }",
+			expected: "test.gcfg:1:14: duplicate key x, first defined at test.gcfg:1:7",
+		},
+		{
+			name:     "Unclosed",
+			input:    "a = { x = 1,",
+			expected: "test.gcfg:1:13: unexpected end of file in inline section, expected key = value or }",
+		},
+		{
+			name:     "ArrayOfSectionsAndValues",
+			input:    "a = [{ x = 1 }, 2]",
+			expected: "test.gcfg:1:17: arrays must be of single type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	input := `[Server] {
+	host = "a"
+	[Route] {
+		path = "/a"
+	}
+	[Route] {
+		path = "/b"
+	}
+	Limits {
+		rate = 10
+	}
+}`
+
+	expected := map[string]any{
+		"Server": []map[string]any{
+			{
+				"host": "a",
+				"Route": []map[string]any{
+					{"path": "/a"},
+					{"path": "/b"},
+				},
+				"Limits": map[string]any{"rate": "10"},
+			},
+		},
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	p.SetMaxDepth(2)
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+	p = New(lexer.NewNamed("test.gcfg", []byte(input)))
+	p.SetMaxDepth(2)
+	file, _ := p.Parse()
+	if pos := file.Positions()["Server[0].Route[1].path"]; pos.Line != 7 || pos.Column != 10 {
+		t.Errorf("File.Positions[Server[0].Route[1].path]=%v, wanted 7:10", pos)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		maxDepth int
+		expected string
+	}{
+		{
+			name:     "Default",
+			input:    input,
+			maxDepth: 0,
+			expected: "test.gcfg:3:2: section Route: nesting past 1 level not allowed\n" +
+				"test.gcfg:6:2: section Route: nesting past 1 level not allowed\n" +
+				"test.gcfg:9:2: section Limits: nesting past 1 level not allowed",
+		},
+		{
+			name:     "TooDeep",
+			input:    "A {\n\tB {\n\t\tC {\n\t\t}\n\t}\n}",
+			maxDepth: 2,
+			expected: "test.gcfg:3:3: section C: nesting past 2 levels not allowed",
+		},
+		{
+			name:     "DuplicateNested",
+			input:    "A {\n\tB {\n\t}\n\tB {\n\t}\n}",
+			maxDepth: 2,
+			expected: "test.gcfg:4:2: duplicate section B, first defined at test.gcfg:2:2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			if tt.maxDepth != 0 {
+				p.SetMaxDepth(tt.maxDepth)
+			}
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInheritance(t *testing.T) {
+	input := `Defaults {
+	host = "localhost"
+	port = 80
+	tls = false
+}
+
+Secure : Defaults {
+	tls = true
+}
+
+Prod : Secure {
+	port = 443
+}
+
+[Server] : Defaults {
+	host = "a"
+}`
+
+	expected := map[string]any{
+		"Defaults": map[string]any{"host": "localhost", "port": "80", "tls": false},
+		"Secure":   map[string]any{"host": "localhost", "port": "80", "tls": true},
+		"Prod":     map[string]any{"host": "localhost", "port": "443", "tls": true},
+		"Server": []map[string]any{
+			{"host": "a", "port": "80", "tls": false},
+		},
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+	// inherited values keep the position they were written at
+	file, _ := New(lexer.NewNamed("test.gcfg", []byte(input))).Parse()
+	if pos := file.Positions()["Prod.host"]; pos.Line != 2 {
+		t.Errorf("File.Positions[Prod.host]=%v, wanted line 2", pos)
+	}
+}
+
+func TestInheritanceErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Missing",
+			input:    "Prod : Defaults {\n}",
+			expected: "test.gcfg:1:8: section Prod: base section Defaults not found",
+		},
+		{
+			name:     "NotSection",
+			input:    "Defaults = 1\nProd : Defaults {\n}",
+			expected: "test.gcfg:2:8: section Prod: base Defaults is a key, not a section",
+		},
+		{
+			name:     "Self",
+			input:    "A : A {\n}",
+			expected: "test.gcfg:1:5: section A: inheritance cycle A : A",
+		},
+		{
+			name:     "Cycle",
+			input:    "A : B {\n}\nB : C {\n}\nC : A {\n}",
+			expected: "test.gcfg:5:5: section C: inheritance cycle A : B : C : A",
+		},
+		{
+			name:     "MissingBaseName",
+			input:    "A : {\n}",
+			expected: "test.gcfg:1:5: unexpected '{' after :, expected base section name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeSections(t *testing.T) {
+	input := `Logging {
+	level = "info"
+}
+
+port = 80
+
+Logging {
+	file = "x"
+}`
+
+	expected := map[string]any{
+		"Logging": map[string]any{"level": "info", "file": "x"},
+		"port":    "80",
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	p.SetMode(MergeSections)
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "DuplicateKey",
+			input:    "Logging {\n\tlevel = \"info\"\n}\nLogging {\n\tlevel = \"debug\"\n}",
+			expected: "test.gcfg:5:2: duplicate key level, first defined at test.gcfg:2:2",
+		},
+		{
+			name:     "KeyAndSection",
+			input:    "Logging = 1\nLogging {\n}",
+			expected: "test.gcfg:2:1: section Logging conflicts with key defined at test.gcfg:1:1",
+		},
+		{
+			name:     "Bases",
+			input:    "A {\n}\nB {\n}\nC : A {\n}\nC : B {\n}",
+			expected: "test.gcfg:7:5: section C: base B conflicts with base A at test.gcfg:5:5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			p.SetMode(MergeSections)
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeNestedSections(t *testing.T) {
+	input := `Server {
+	Limits {
+		rate = 1
+	}
+}
+
+Server {
+	host = "a"
+	Limits {
+		burst = 2
+	}
+}`
+
+	expected := map[string]any{
+		"Server": map[string]any{
+			"host":   "a",
+			"Limits": map[string]any{"rate": "1", "burst": "2"},
+		},
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	p.SetMode(MergeSections)
+	p.SetMaxDepth(2)
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+}
+
+func TestDottedKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected map[string]any
+	}{
+		{
+			name:     "Alone",
+			input:    "Server.port = 8080\nServer.host = \"a\"",
+			expected: map[string]any{"Server": map[string]any{"port": "8080", "host": "a"}},
+		},
+		{
+			name:     "AfterBlock",
+			input:    "Server {\n\thost = \"a\"\n}\nServer.port = 8080",
+			expected: map[string]any{"Server": map[string]any{"port": "8080", "host": "a"}},
+		},
+		{
+			name:     "BeforeBlock",
+			input:    "Server.port = 8080\nServer {\n\thost = \"a\"\n}",
+			expected: map[string]any{"Server": map[string]any{"port": "8080", "host": "a"}},
+		},
+		{
+			name:  "NextToKeys",
+			input: "a = 1\nServer.port = 8080\nb = 2",
+			expected: map[string]any{
+				"a":      "1",
+				"b":      "2",
+				"Server": map[string]any{"port": "8080"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			output, err := p.ParseFile()
+
+			if err != nil || !reflect.DeepEqual(output, tt.expected) {
+				t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDottedKeysNested(t *testing.T) {
+	input := "Server.Limits.rate = 1\nServer {\n\tLimits {\n\t\tburst = 2\n\t}\n}"
+	expected := map[string]any{
+		"Server": map[string]any{
+			"Limits": map[string]any{"rate": "1", "burst": "2"},
+		},
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	p.SetMaxDepth(2)
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+}
+
+func TestDottedKeyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Duplicate",
+			input:    "Server {\n\tport = 1\n}\nServer.port = 2",
+			expected: "test.gcfg:4:8: duplicate key port, first defined at test.gcfg:2:2",
+		},
+		{
+			name:     "TwoBlocks",
+			input:    "Server.port = 1\nServer {\n}\nServer {\n}",
+			expected: "test.gcfg:4:1: duplicate section Server, first defined at test.gcfg:1:1",
+		},
+		{
+			name:     "SectionArray",
+			input:    "[Server] {\n}\nServer.port = 1",
+			expected: "test.gcfg:3:1: section Server conflicts with section array defined at test.gcfg:1:1",
+		},
+		{
+			name:     "TooDeep",
+			input:    "Server.Limits.rate = 1",
+			expected: "test.gcfg:1:1: key Server.Limits.rate: nesting past 1 level not allowed",
+		},
+		{
+			name:     "NoKey",
+			input:    "Server. = 1",
+			expected: "test.gcfg:1:9: unexpected '=' after Server., expected key name",
+		},
+		{
+			name:     "NoAssign",
+			input:    "Server.port 1",
+			expected: "test.gcfg:1:13: unexpected number 1 after Server.port, expected =",
+		},
+		{
+			name:     "InSection",
+			input:    "Server {\n\tLimits.rate = 1\n}",
+			expected: "test.gcfg:2:2: unexpected identifier Limits in section Server, expected key = value or }",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/main.gcfg": {Data: []byte("@include \"db.gcfg\"\nname = \"main\"\n@include \"conf.d/*.gcfg\"")},
+		"conf/db.gcfg":   {Data: []byte("Db {\n\thost = \"localhost\"\n}")},
+		// paths in an included file are relative to it, not the top level file
+		"conf/conf.d/a.gcfg":      {Data: []byte("@include \"../shared/limits.gcfg\"\na = 1")},
+		"conf/conf.d/b.gcfg":      {Data: []byte("b = 2")},
+		"conf/conf.d/skip.txt":    {Data: []byte("not a config")},
+		"conf/shared/limits.gcfg": {Data: []byte("Db.port = 5432")},
+	}
+
+	expected := map[string]any{
+		"Db":   map[string]any{"host": "localhost", "port": "5432"},
+		"name": "main",
+		"a":    "1",
+		"b":    "2",
+	}
+
+	p := New(lexer.NewNamed("conf/main.gcfg", fsys["conf/main.gcfg"].Data))
+	p.SetFS(fsys)
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+	p = New(lexer.NewNamed("conf/main.gcfg", fsys["conf/main.gcfg"].Data))
+	p.SetFS(fsys)
+	file, _ := p.Parse()
+	if pos := file.Positions()["Db.host"]; pos.Filename != "conf/db.gcfg" || pos.Line != 2 {
+		t.Errorf("File.Positions[Db.host]=%v, wanted conf/db.gcfg:2:2", pos)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.gcfg":      {Data: []byte("@include \"b.gcfg\"")},
+		"b.gcfg":      {Data: []byte("x = 1\n@include \"a.gcfg\"")},
+		"bad.gcfg":    {Data: []byte("x = 1\ny = ")},
+		"nested.gcfg": {Data: []byte("z = 1\n@include \"bad.gcfg\"")},
+		"dup.gcfg":    {Data: []byte("port = 2")},
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Missing",
+			input:    `@include "missing.gcfg"`,
+			expected: `main.gcfg:1:1: @include "missing.gcfg": open missing.gcfg: file does not exist`,
+		},
+		{
+			name:     "Cycle",
+			input:    `@include "a.gcfg"`,
+			expected: `main.gcfg:1:1: @include "a.gcfg": a.gcfg:1:1: @include "b.gcfg": b.gcfg:2:1: @include "a.gcfg": include cycle a.gcfg -> b.gcfg -> a.gcfg`,
+		},
+		{
+			name:     "Self",
+			input:    `@include "main.gcfg"`,
+			expected: `main.gcfg:1:1: @include "main.gcfg": include cycle main.gcfg -> main.gcfg`,
+		},
+		{
+			name:  "Trace",
+			input: "a = 1\n@include \"nested.gcfg\"",
+			expected: `main.gcfg:2:1: @include "nested.gcfg": nested.gcfg:2:1: @include "bad.gcfg": ` +
+				`bad.gcfg:2:5: invalid value`,
+		},
+		{
+			name:     "DuplicateAcrossFiles",
+			input:    "port = 1\n@include \"dup.gcfg\"",
+			expected: `main.gcfg:2:1: @include "dup.gcfg": dup.gcfg:1:1: duplicate key port, first defined at main.gcfg:1:1`,
+		},
+		{
+			name:     "BadPattern",
+			input:    `@include "[.gcfg"`,
+			expected: `main.gcfg:1:1: @include "[.gcfg": syntax error in pattern`,
+		},
+		{
+			name:     "NoFileName",
+			input:    "@include db",
+			expected: "main.gcfg:1:10: unexpected identifier db after @include, expected file name",
+		},
+		{
+			name:     "UnknownDirective",
+			input:    `@import "db.gcfg"`,
+			expected: "main.gcfg:1:1: unknown directive @import",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("main.gcfg", []byte(tt.input)))
+			p.SetFS(fsys)
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+
+	p := New(lexer.NewNamed("main.gcfg", []byte(`@include "a.gcfg"`)))
+	_, err := p.Parse()
+
+	expected := `main.gcfg:1:1: @include "a.gcfg": no file system to include from`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Parse error=%v, wanted %s", err, expected)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	input := `base = "/api"
+url = "https://${Server.host}:${Server.port}${base}/v1"
+port = ${Server.port}
+backup = "${Backend[1].host}"
+hosts = [${Backend[0].host}, "${Backend[1].host}", "c"]
+price = "$${not.a.ref} ${Limits.rate} ${Limits.strict}"
+later = ${url}
+
+Server {
+	host = "example.com"
+	port = 8080
+}
+
+[Backend] {
+	host = "a"
+}
+
+[Backend] {
+	host = "b"
+}
+
+Limits {
+	rate = 1.5
+	strict = true
+}`
+
+	expected := map[string]any{
+		"base":   "/api",
+		"url":    "https://example.com:8080/api/v1",
+		"port":   "8080",
+		"backup": "b",
+		"hosts":  []any{"a", "b", "c"},
+		"price":  "${not.a.ref} 1.5 true",
+		"later":  "https://example.com:8080/api/v1",
+		"Server": map[string]any{"host": "example.com", "port": "8080"},
+		"Backend": []map[string]any{
+			{"host": "a"},
+			{"host": "b"},
+		},
+		"Limits": map[string]any{"rate": 1.5, "strict": true},
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+}
+
+func TestRawStringReferences(t *testing.T) {
+	input := "path = `C:\\${PATH}`\nescaped = `$${x}`"
+	expected := map[string]any{"path": `C:\${PATH}`, "escaped": "$${x}"}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(input)))
+	output, err := p.ParseFile()
+
+	if err != nil || !reflect.DeepEqual(output, expected) {
+		t.Errorf("ParseFile=%v, %v, wanted match for %v", output, err, expected)
+	}
+}
+
+func TestReferenceChain(t *testing.T) {
+	// each array refers to the one before it twice, so walking every reference instead of every value takes 2^n steps
+	const n = 64
+
+	var b strings.Builder
+	b.WriteString("a0 = [1]\n")
+	for i := 1; i < n; i++ {
+		fmt.Fprintf(&b, "a%d = [${a%d}, ${a%d}]\n", i, i-1, i-1)
+	}
+
+	p := New(lexer.NewNamed("test.gcfg", []byte(b.String())))
+	file, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse error=%v, wanted nil", err)
+	}
+
+	last := file.Decls[n-1].(*Assignment).Value.(*ArrayLit)
+	prev := file.Decls[n-2].(*Assignment).Value
+	if len(last.Elems) != 2 || last.Elems[0] != prev || last.Elems[1] != prev {
+		t.Errorf("Parse=%v, wanted both elements to be %v", last.Elems, prev)
+	}
+}
+
+func TestReferenceErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Unresolved",
+			input:    "a = ${Server.host}",
+			expected: "test.gcfg:1:5: unresolved reference ${Server.host}",
+		},
+		{
+			name:     "UnresolvedInString",
+			input:    `a = "http://${host}"`,
+			expected: "test.gcfg:1:5: unresolved reference ${host}",
+		},
+		{
+			name:     "OutOfRange",
+			input:    "[Server] {\n\thost = \"a\"\n}\na = ${Server[1].host}",
+			expected: "test.gcfg:4:5: unresolved reference ${Server[1].host}",
+		},
+		{
+			name:     "Section",
+			input:    "Server {\n}\na = ${Server}",
+			expected: "test.gcfg:3:5: reference ${Server} is a section, not a value",
+		},
+		{
+			name:     "Self",
+			input:    "a = ${a}",
+			expected: "test.gcfg:1:5: reference cycle a -> a",
+		},
+		{
+			name:     "Cycle",
+			input:    "a = \"${b}\"\nb = ${c}\nc = \"x${a}\"",
+			expected: "test.gcfg:3:5: reference cycle a -> b -> c -> a",
+		},
+		{
+			name:     "CycleThroughInheritance",
+			input:    "Base {\n\ta = \"${Prod.b}\"\n}\nProd : Base {\n\tb = \"${Prod.a}\"\n}",
+			expected: "test.gcfg:5:6: reference cycle Base.a -> Prod.b -> Prod.a",
+		},
+		{
+			name:     "MixedArray",
+			input:    "s = \"x\"\na = [1, ${s}]",
+			expected: "test.gcfg:2:9: arrays must be of single type",
+		},
+		{
+			name:     "MixedNestedArray",
+			input:    "s = \"x\"\na = [[1], [${s}]]",
+			expected: "test.gcfg:2:11: arrays must be of single type",
+		},
+		{
+			name:     "NotText",
+			input:    "a = [1]\nb = \"${a}\"",
+			expected: "test.gcfg:2:5: reference ${a} in string is not a string, number or bool",
+		},
+		{
+			name:     "UnterminatedInString",
+			input:    `a = "${b"`,
+			expected: "test.gcfg:1:5: unterminated reference in string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.NewNamed("test.gcfg", []byte(tt.input)))
+			_, err := p.Parse()
+
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Parse error=%v, wanted %s", err, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package parser
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/grian32/gcfg/lexer"
+)
+
+// resolveRefs replaces every ${path} reference in decls with the value path refers to, and every ${path} in a string
+// with that value's text. $${ in a string is a literal ${, and raw strings aren't interpolated.
+func (p *Parser) resolveRefs(decls []Decl) {
+	r := &refResolver{
+		p:       p,
+		targets: make(map[string]Node),
+		refs:    make(map[*RefLit]Value),
+		done:    make(map[Value]bool),
+	}
+	walkPaths(decls, "", func(path string, node Node) {
+		r.targets[path] = node
+	})
+	r.resolveDecls(decls, "")
+}
+
+type chainLink struct {
+	path  string
+	value Value
+}
+
+type refResolver struct {
+	p *Parser
+	// targets holds everything a reference can point at, by path.
+	targets map[string]Node
+	// chain holds the values being resolved, outermost first, to catch a value referring to itself. Inherited values
+	// are shared between sections, so a value can come round again under a different path.
+	chain []chainLink
+	// refs holds the references that have been resolved already, and done the strings, arrays, pairs and inline
+	// sections. A resolved reference shares the value it refers to, so without them a value reached by many references
+	// would be walked again for each one.
+	refs map[*RefLit]Value
+	done map[Value]bool
+}
+
+func (r *refResolver) resolveDecls(decls []Decl, path string) {
+	elems := make(map[string]int)
+
+	for _, decl := range decls {
+		name := DeclName(decl)
+		namePath := memberPath(path, name)
+
+		switch decl := decl.(type) {
+		case *Assignment:
+			decl.Value = r.resolve(decl.Value, namePath)
+		case *Section:
+			r.resolveDecls(decl.Body, namePath)
+		case *SectionArray:
+			r.resolveDecls(decl.Body, indexPath(namePath, elems[name]))
+			elems[name]++
+		}
+	}
+}
+
+// resolve returns value, found at path, with any references in it resolved. A reference is resolved to the value it
+// refers to.
+func (r *refResolver) resolve(value Value, path string) Value {
+	if r.done[value] {
+		return value
+	}
+
+	r.chain = append(r.chain, chainLink{path, value})
+	defer func() { r.chain = r.chain[:len(r.chain)-1] }()
+
+	switch value := value.(type) {
+	case *RefLit:
+		resolved, ok := r.refs[value]
+		if !ok {
+			resolved = r.lookup(value.Path, value.Pos())
+			if resolved == nil {
+				resolved = value
+			}
+			r.refs[value] = resolved
+		}
+		return resolved
+	case *StringLit:
+		r.done[value] = true
+		value.Value = r.interpolate(value)
+	case *ArrayLit:
+		r.done[value] = true
+		var shape Value
+		mixed := false
+		for i, elem := range value.Elems {
+			value.Elems[i] = r.resolve(elem, indexPath(path, i))
+
+			// references weren't known when the array was parsed, so its elements are checked again
+			elemShape := r.p.shapeOf(value.Elems[i])
+			if shape != nil && !mixed && !sameShape(shape, elemShape) {
+				r.p.error(lexer.Errorf(elem.Pos(), "arrays must be of single type"))
+				mixed = true
+			}
+			shape = mergeShapes(shape, elemShape)
+		}
+		r.p.shapes[value] = shape
+	case *PairLit:
+		r.done[value] = true
+		value.First = r.resolve(value.First, memberPath(path, "First"))
+		value.Second = r.resolve(value.Second, memberPath(path, "Second"))
+	case *SectionLit:
+		r.done[value] = true
+		r.resolveDecls(value.Body, path)
+	}
+
+	return value
+}
+
+// lookup returns the resolved value that the reference to path made at pos refers to, or nil if it can't be resolved.
+func (r *refResolver) lookup(path string, pos lexer.Position) Value {
+	target, ok := r.targets[path]
+	if !ok {
+		r.p.error(lexer.Errorf(pos, "unresolved reference ${%s}", path))
+		return nil
+	}
+
+	value, ok := target.(Value)
+	if !ok {
+		r.p.error(lexer.Errorf(pos, "reference ${%s} is a %s, not a value", path, declKind(target.(Decl))))
+		return nil
+	}
+
+	i := slices.IndexFunc(r.chain, func(link chainLink) bool {
+		return link.path == path || link.value == value
+	})
+	if i >= 0 {
+		cycle := make([]string, 0, len(r.chain)-i+1)
+		for _, link := range r.chain[i:] {
+			cycle = append(cycle, link.path)
+		}
+		cycle = append(cycle, path)
+		r.p.error(lexer.Errorf(pos, "reference cycle %s", strings.Join(cycle, " -> ")))
+		return nil
+	}
+
+	return r.resolve(value, path)
+}
+
+// interpolate returns the contents of str with each ${path} replaced by the text of the value it refers to. Raw
+// strings are left as they are.
+func (r *refResolver) interpolate(str *StringLit) string {
+	rest := str.Value
+	if str.Raw || !strings.Contains(rest, "${") {
+		return rest
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String()
+		}
+
+		// $${ is an escaped ${
+		if start > 0 && rest[start-1] == '$' {
+			b.WriteString(rest[:start-1])
+			b.WriteString("${")
+			rest = rest[start+len("${"):]
+			continue
+		}
+
+		b.WriteString(rest[:start])
+		rest = rest[start+len("${"):]
+
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			r.p.error(lexer.Errorf(str.Pos(), "unterminated reference in string"))
+			return str.Value
+		}
+		path := strings.TrimSpace(rest[:end])
+		rest = rest[end+len("}"):]
+
+		value := r.lookup(path, str.Pos())
+		if value == nil {
+			return str.Value
+		}
+		text, ok := valueText(value)
+		if !ok {
+			r.p.error(lexer.Errorf(str.Pos(), "reference ${%s} in string is not a string, number or bool", path))
+			return str.Value
+		}
+		b.WriteString(text)
+	}
+}
+
+// valueText returns the text a simple value is interpolated into a string as.
+func valueText(value Value) (string, bool) {
+	switch value := value.(type) {
+	case *StringLit:
+		return value.Value, true
+	case *IntLit:
+		return value.Literal, true
+	case *FloatLit:
+		return value.Literal, true
+	case *BoolLit:
+		return strconv.FormatBool(value.Value), true
+	default:
+		return "", false
+	}
+}